@@ -0,0 +1,61 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// CommonLogFormatFinalizer returns a finalizer, for use with ServerFinalizer,
+// that writes an access log line per request to w in the Common Log Format,
+// followed by the time taken to serve the request in microseconds, like
+// Apache's %D:
+//
+//	127.0.0.1 - alice [10/Oct/2000:13:55:36 -0700] "POST /rpc HTTP/1.1" 200 2326 1520
+//
+// The user is taken from the request's basic auth credentials, if any.
+func CommonLogFormatFinalizer(w io.Writer) httptransport.ServerFinalizerFunc {
+	var mtx sync.Mutex
+	return func(ctx context.Context, code int, r *http.Request) {
+		var (
+			end      = time.Now()
+			begin, _ = ctx.Value(contextKeyBegin).(time.Time)
+			size, _  = ctx.Value(httptransport.ContextKeyResponseSize).(int64)
+		)
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user, _, _ := r.BasicAuth()
+		bytes := "-"
+		if size > 0 {
+			bytes = fmt.Sprint(size)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %s %d\n",
+			orDash(host),
+			orDash(user),
+			end.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method,
+			r.URL.RequestURI(),
+			r.Proto,
+			code,
+			bytes,
+			end.Sub(begin)/time.Microsecond,
+		)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
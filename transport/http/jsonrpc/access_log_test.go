@@ -0,0 +1,32 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestCommonLogFormatFinalizer(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ServerFinalizer(jsonrpc.CommonLogFormatFinalizer(&buf)),
+	)
+	header := http.Header{"Authorization": {"Basic YWxpY2U6c2VjcmV0"}} // alice:secret
+	resp := postHeader(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, header)
+	resp.Body.Close()
+
+	const size = len(`{"jsonrpc":"2.0","id":1,"result":3}` + "\n")
+	re := regexp.MustCompile(`^127\.0\.0\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "POST / HTTP/1\.1" 200 (\d+) \d+\n$`)
+	m := re.FindStringSubmatch(buf.String())
+	if m == nil {
+		t.Fatalf("unexpected log line %q", buf.String())
+	}
+	if want, have := size, m[1]; strconv.Itoa(want) != have {
+		t.Errorf("want %d bytes, have %s", want, have)
+	}
+}
@@ -0,0 +1,103 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+// AuditEntry records the outcome of a single JSON-RPC request.
+type AuditEntry struct {
+	Time time.Time
+
+	// Principal is the name of the Principal stored in the context by
+	// ServerAuthenticator, or empty if the request wasn't authenticated.
+	Principal string
+
+	Method string
+
+	// ParamsHash is the hex-encoded SHA-256 of the params as sent by the
+	// client. Params decoded while they're streamed by a ParamsStreamHandler
+	// aren't buffered, so they're hashed as if empty.
+	ParamsHash string
+
+	// Code is the JSON-RPC code of the error the request failed with, or 0
+	// if it succeeded.
+	Code int
+}
+
+// AuditLogger passes an AuditEntry to log once each request, including each
+// request of a batch, has been served, whether it succeeded or not. HTTP
+// requests that fail as a whole, e.g. because they're rejected by
+// ServerErroringBefore, ServerAuthenticator or BearerAuth, or their body
+// can't be decoded, get an entry too once they're answered; as the body isn't
+// decoded by then, its Method is empty and its ParamsHash is that of empty
+// params. Requests for the methods in exclude, typically reads, aren't
+// audited. log may be called concurrently for the requests of a batch. By
+// default, nothing is audited.
+func AuditLogger(log func(AuditEntry), exclude ...string) ServerOption {
+	return func(s *Server) {
+		s.audit = log
+		s.auditExclude = map[string]bool{}
+		for _, method := range exclude {
+			s.auditExclude[method] = true
+		}
+	}
+}
+
+// auditRequest passes the entry of req, which failed with err if non-nil, to
+// the server's AuditLogger, if any.
+func (s Server) auditRequest(ctx context.Context, req Request, err error) {
+	if st, ok := ctx.Value(contextKeyAudit).(*auditState); ok {
+		st.audited.Store(true)
+	}
+	if s.audit == nil || s.auditExclude[req.Method] {
+		return
+	}
+	sum := sha256.Sum256(req.Params)
+	e := AuditEntry{
+		Time:       time.Now(),
+		Method:     req.Method,
+		ParamsHash: hex.EncodeToString(sum[:]),
+	}
+	if p, ok := PrincipalFromContext(ctx); ok {
+		e.Principal = p.Name()
+	}
+	if err != nil {
+		e.Code = ToJSONRPCError(err).Code
+	}
+	s.audit(e)
+}
+
+// auditState tracks the auditing of an HTTP request served by a Server, so
+// that a request failing as a whole still gets an entry.
+type auditState struct {
+	audited atomic.Bool // whether any request in the body was audited
+	ctx     context.Context
+	err     error
+}
+
+// startAudit returns a copy of ctx tracking the auditing of an HTTP request,
+// and a function to defer, which audits the request if it failed as a whole
+// and nothing else audited it.
+func (s Server) startAudit(ctx context.Context) (context.Context, func()) {
+	if s.audit == nil {
+		return ctx, func() {}
+	}
+	st := &auditState{}
+	return context.WithValue(ctx, contextKeyAudit, st), func() {
+		if st.err != nil && !st.audited.Load() {
+			s.auditRequest(st.ctx, Request{}, st.err)
+		}
+	}
+}
+
+// auditFailure records err, which failed the HTTP request in ctx as a whole,
+// for the function returned by startAudit.
+func auditFailure(ctx context.Context, err error) {
+	if st, ok := ctx.Value(contextKeyAudit).(*auditState); ok {
+		st.ctx, st.err = ctx, err
+	}
+}
@@ -0,0 +1,89 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestServerAuditLogger(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		entries []jsonrpc.AuditEntry
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(), "get": addService()},
+		jsonrpc.ServerAuthenticator(func(_ context.Context, h http.Header) (jsonrpc.Principal, error) {
+			return user{name: h.Get("X-User")}, nil
+		}),
+		jsonrpc.AuditLogger(func(e jsonrpc.AuditEntry) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			entries = append(entries, e)
+		}, "get"),
+	)
+	header := http.Header{"X-User": {"alice"}}
+	postHeader(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`, header).Body.Close()
+	postHeader(t, handler, `{"jsonrpc":"2.0","id":1,"method":"get","params":[1,2]}`, header).Body.Close()
+	postHeader(t, handler, `[{"jsonrpc":"2.0","id":1,"method":"get","params":[1,2]},{"jsonrpc":"2.0","id":2,"method":"add","params":"x"}]`, header).Body.Close()
+
+	if want, have := 2, len(entries); want != have {
+		t.Fatalf("want %d entries, have %d: %+v", want, have, entries)
+	}
+	for i, tc := range []struct {
+		params string
+		code   int
+	}{
+		{`[1,2]`, 0},
+		{`"x"`, jsonrpc.InternalError},
+	} {
+		e := entries[i]
+		sum := sha256.Sum256([]byte(tc.params))
+		if e.Principal != "alice" || e.Method != "add" || e.ParamsHash != hex.EncodeToString(sum[:]) || e.Code != tc.code {
+			t.Errorf("entry %d: want alice, add, hash of %s and code %d, have %+v", i, tc.params, tc.code, e)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("entry %d: time not set", i)
+		}
+	}
+}
+
+func TestServerAuditLoggerRejected(t *testing.T) {
+	var entries []jsonrpc.AuditEntry
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ServerAuthenticator(func(_ context.Context, h http.Header) (jsonrpc.Principal, error) {
+			if h.Get("X-User") == "" {
+				return nil, jsonrpc.HTTPError{Code: jsonrpc.UnauthorizedError, Status: http.StatusUnauthorized}
+			}
+			return user{name: h.Get("X-User")}, nil
+		}),
+		jsonrpc.AuditLogger(func(e jsonrpc.AuditEntry) { entries = append(entries, e) }),
+	)
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`)
+	resp.Body.Close()
+	if want, have := http.StatusUnauthorized, resp.StatusCode; want != have {
+		t.Fatalf("want status %d, have %d", want, have)
+	}
+	postHeader(t, handler, `{"jsonrpc":"2.0","id":1,`, http.Header{"X-User": {"alice"}}).Body.Close()
+
+	if want, have := 2, len(entries); want != have {
+		t.Fatalf("want %d entries, have %d: %+v", want, have, entries)
+	}
+	for i, tc := range []struct {
+		principal string
+		code      int
+	}{
+		{"", jsonrpc.UnauthorizedError},
+		{"alice", jsonrpc.ParseError},
+	} {
+		if e := entries[i]; e.Principal != tc.principal || e.Method != "" || e.Code != tc.code || e.Time.IsZero() {
+			t.Errorf("entry %d: want principal %q, no method and code %d, have %+v", i, tc.principal, tc.code, e)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Principal is the authenticated identity on whose behalf a request is made.
+type Principal interface {
+	Name() string
+}
+
+// ServerAuthenticator authenticates every request with authenticate before it
+// is dispatched. On success, the returned Principal is stored in the context,
+// where it can be retrieved with PrincipalFromContext. On failure, the request
+// isn't dispatched and the returned error is passed to the error encoder, so
+// authenticate decides the error seen by the client, e.g. by returning an
+// Error or HTTPError.
+func ServerAuthenticator(authenticate func(context.Context, http.Header) (Principal, error)) ServerOption {
+	return ServerErroringBefore(func(ctx context.Context, h http.Header) (context.Context, error) {
+		p, err := authenticate(ctx, h)
+		if err != nil {
+			return ctx, err
+		}
+		return context.WithValue(ctx, contextKeyPrincipal, p), nil
+	})
+}
+
+// PrincipalFromContext returns the Principal stored in the context by
+// ServerAuthenticator, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKeyPrincipal).(Principal)
+	return p, ok
+}
+
+// Claims are the verified claims of a bearer token.
+type Claims map[string]interface{}
+
+// BearerAuth authenticates every request by its "Authorization: Bearer"
+// header before it is dispatched. The token is checked with verify, and the
+// claims it returns are stored in the context under claimsKey. Requests
+// without a token, or whose token verify rejects, aren't dispatched and get
+// an UnauthorizedError with an HTTP status of 401; the error returned by
+// verify isn't disclosed to the client.
+func BearerAuth(verify func(token string) (Claims, error), claimsKey interface{}) ServerOption {
+	return ServerErroringBefore(func(ctx context.Context, h http.Header) (context.Context, error) {
+		parts := strings.SplitN(h.Get("Authorization"), " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || parts[1] == "" {
+			return ctx, unauthorized("missing bearer token", "Bearer")
+		}
+		claims, err := verify(parts[1])
+		if err != nil {
+			return ctx, unauthorized("invalid bearer token", `Bearer error="invalid_token"`)
+		}
+		return context.WithValue(ctx, claimsKey, claims), nil
+	})
+}
+
+func unauthorized(message, challenge string) error {
+	return HTTPError{
+		Code:    UnauthorizedError,
+		Message: message,
+		Status:  http.StatusUnauthorized,
+		Header:  http.Header{"Www-Authenticate": {challenge}},
+	}
+}
@@ -0,0 +1,105 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+type user struct {
+	name  string
+	admin bool
+}
+
+func (u user) Name() string { return u.name }
+
+func whoamiService() *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			p, ok := jsonrpc.PrincipalFromContext(ctx)
+			if !ok {
+				return nil, nil
+			}
+			u := p.(user)
+			return map[string]interface{}{"name": u.name, "admin": u.admin}, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+}
+
+func TestServerAuthenticator(t *testing.T) {
+	const unauthorized = jsonrpc.UnauthorizedError
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"whoami": whoamiService()},
+		jsonrpc.ServerAuthenticator(func(_ context.Context, h http.Header) (jsonrpc.Principal, error) {
+			if h.Get("X-User") == "" {
+				return nil, jsonrpc.Error{Code: unauthorized, Message: "unauthorized"}
+			}
+			return user{name: h.Get("X-User"), admin: true}, nil
+		}),
+	)
+	const body = `{"jsonrpc":"2.0","id":1,"method":"whoami"}`
+
+	resp := postHeader(t, handler, body, http.Header{"X-User": {"alice"}})
+	res := decodeResponse(t, resp)
+	if want, have := `{"admin":true,"name":"alice"}`, string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	res = decodeResponse(t, post(t, handler, body))
+	if want, have := unauthorized, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	type claimsKey struct{}
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"whoami": jsonrpc.NewService(
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return ctx.Value(claimsKey{}), nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		)},
+		jsonrpc.BearerAuth(func(token string) (jsonrpc.Claims, error) {
+			if token != "valid" {
+				return nil, errors.New("signature mismatch")
+			}
+			return jsonrpc.Claims{"sub": "alice"}, nil
+		}, claimsKey{}),
+	)
+	const body = `{"jsonrpc":"2.0","method":"whoami","id":1}`
+
+	res := decodeResponse(t, postHeader(t, handler, body, http.Header{"Authorization": {"Bearer valid"}}))
+	if want, have := `{"sub":"alice"}`, string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	for name, header := range map[string]http.Header{
+		"invalid":    {"Authorization": {"Bearer forged"}},
+		"missing":    {},
+		"not bearer": {"Authorization": {"Basic YWxpY2U6c2VjcmV0"}},
+	} {
+		resp := postHeader(t, handler, body, header)
+		if want, have := http.StatusUnauthorized, resp.StatusCode; want != have {
+			t.Errorf("%s: want status %d, have %d", name, want, have)
+		}
+		if resp.Header.Get("Www-Authenticate") == "" {
+			t.Errorf("%s: want WWW-Authenticate header", name)
+		}
+		res := decodeResponse(t, resp)
+		if want, have := jsonrpc.UnauthorizedError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", name, want, have)
+		}
+		if strings.Contains(res.Error.Message, "signature") {
+			t.Errorf("%s: verify error disclosed: %q", name, res.Error.Message)
+		}
+	}
+}
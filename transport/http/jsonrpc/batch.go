@@ -0,0 +1,323 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+// isBatch reports whether the body buffered by br holds a batch, i.e. starts
+// with an array. It consumes leading whitespace only.
+func isBatch(br *bufio.Reader) bool {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// serveBatch serves the requests of a batch as they're decoded from dec, so
+// the batch is never held in memory as a whole, nor are its results: up to
+// BatchConcurrency requests are served at once, and every request but the
+// notifications gets a response, written in order as soon as it and those
+// before it are ready. The headers set by the handlers are only applied while
+// the response hasn't been started. A batch of notifications only is answered
+// with an HTTP status of 204. If the batch turns out not to be valid JSON
+// before anything is written, it's answered with a single error, as for a
+// single request; otherwise the responses written so far are closed off.
+// Likewise, a result that can't be encoded fails the whole batch with an
+// InternalError if nothing is written yet, and only its own request otherwise.
+func (s Server) serveBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, dec *json.Decoder, head *prefixWriter) {
+	bw := &batchWriter{ctx: ctx, w: w, logger: s.logger, header: http.Header{}}
+	n, err := s.serveBatchRequests(ctx, r.Header, dec, bw.add)
+	switch {
+	case bw.encodeErr != nil && !bw.started():
+		s.errorEncoder(ctx, internalError{bw.encodeErr}, w)
+		return
+	case err != nil && !bw.started():
+		s.decodeError(ctx, w, err, head)
+		return
+	case err != nil:
+		s.logger.Log("err", err)
+	case n == 0:
+		s.fail(ctx, w, invalidRequestError{})
+		return
+	case !bw.opened:
+		for k, v := range bw.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := bw.close(); err != nil {
+		s.logger.Log("err", "can't write response: "+err.Error())
+	}
+}
+
+// batchWriter writes the responses of a batch to w one by one. Until the
+// output is known to reach the compression threshold, if any, it's held back,
+// and nothing is written to w.
+type batchWriter struct {
+	ctx       context.Context
+	w         http.ResponseWriter
+	logger    log.Logger
+	header    http.Header // the handlers' headers, applied when the output starts
+	opened    bool        // whether a response was added
+	held      []byte
+	out       io.Writer // w, or zw; nil until the output starts
+	zw        *gzip.Writer
+	err       error
+	encodeErr error // the first result that couldn't be encoded
+}
+
+// add adds the response of rep, if any, and collects its headers while the
+// output hasn't started. A response that can't be encoded is replaced by an
+// InternalError once the output has started; before that, it fails the batch,
+// and the responses that follow are dropped.
+func (b *batchWriter) add(rep reply) {
+	if b.out == nil {
+		for k, v := range rep.rh {
+			b.header[k] = v
+		}
+	}
+	if rep.res == nil || (b.encodeErr != nil && b.out == nil) {
+		return
+	}
+	indent, _ := b.ctx.Value(contextKeyIndent).(string)
+	buf, err := marshalIndent(rep.res, indent)
+	if err != nil {
+		b.logger.Log("err", "can't write response: "+err.Error())
+		if b.out == nil {
+			b.encodeErr = err
+			return
+		}
+		buf, _ = marshalIndent(newResponse(rep.ctx, nil, localizedError(rep.ctx, internalError{err})), indent)
+	}
+	sep := ","
+	if !b.opened {
+		b.opened, sep = true, "["
+	}
+	if indent != "" {
+		sep += "\n" + indent
+	}
+	b.write(append([]byte(sep), buf...))
+}
+
+// close ends the output, writing it at once if it was held back.
+func (b *batchWriter) close() error {
+	end := "]\n"
+	if indent, _ := b.ctx.Value(contextKeyIndent).(string); indent != "" {
+		end = "\n]\n"
+	}
+	b.write([]byte(end))
+	if b.out == nil && b.err == nil {
+		b.start(false)
+		_, b.err = b.w.Write(b.held)
+	}
+	if b.zw != nil && b.err == nil {
+		b.err = b.zw.Close()
+	}
+	return b.err
+}
+
+// marshalIndent encodes v as an element of an array indented with indent.
+func marshalIndent(v interface{}, indent string) ([]byte, error) {
+	if indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, indent, indent)
+}
+
+// started reports whether anything was written to w.
+func (b *batchWriter) started() bool { return b.out != nil }
+
+func (b *batchWriter) write(p []byte) {
+	if b.err != nil {
+		return
+	}
+	if b.out == nil {
+		b.held = append(b.held, p...)
+		min, ok := b.ctx.Value(contextKeyCompressMin).(int)
+		if ok && len(b.held) <= min {
+			return
+		}
+		b.start(ok)
+		p, b.held = b.held, nil
+	}
+	_, b.err = b.out.Write(p)
+}
+
+// start writes the status and headers of the response, and directs the output
+// to w, compressing it if gz is set.
+func (b *batchWriter) start(gz bool) {
+	for k, v := range b.header {
+		b.w.Header()[k] = v
+	}
+	b.w.Header().Set("Content-Type", ContentType)
+	b.out = b.w
+	if gz {
+		b.w.Header().Set("Content-Encoding", "gzip")
+		b.w.Header().Del("Content-Length")
+		b.zw = gzip.NewWriter(b.w)
+		b.out = b.zw
+	}
+	b.w.WriteHeader(http.StatusOK)
+}
+
+// serveBatchRequests decodes the requests of a batch from dec and serves
+// them, up to BatchConcurrency at once, with the given request headers. It
+// passes their replies to emit in order, each as soon as it and those before
+// it are ready, so that at most BatchConcurrency replies are held at once, and
+// returns the number of requests, or the error the batch couldn't be decoded
+// with. Failed requests are passed to the BatchErrorAggregator, if any.
+func (s Server) serveBatchRequests(ctx context.Context, h http.Header, dec *json.Decoder, emit func(reply)) (int, error) {
+	if err := expectDelim(dec, '['); err != nil {
+		return 0, err
+	}
+
+	workers := s.batchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		n       int
+		errs    []error
+		sem     = make(chan struct{}, workers)
+		queue   = make(chan chan reply, workers)
+		emitted = make(chan struct{})
+		co      *coalescer
+	)
+	if s.coalesce != nil {
+		co = &coalescer{key: s.coalesce, logger: s.logger, calls: map[string]*coalescedCall{}}
+	}
+	go func() {
+		defer close(emitted)
+		for c := range queue {
+			rep := <-c
+			if rep.err != nil {
+				errs = append(errs, rep.err)
+			}
+			emit(rep)
+		}
+	}()
+	err := func() error {
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			n++
+			c := make(chan reply, 1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem }()
+				c <- s.serveBatchRequest(ctx, h, raw, co)
+			}()
+			queue <- c
+		}
+		return expectDelim(dec, ']')
+	}()
+	close(queue)
+	<-emitted
+	if err != nil {
+		return n, err
+	}
+
+	if s.batchErrors != nil && len(errs) > 0 {
+		s.batchErrors(ctx, errs)
+	}
+	return n, nil
+}
+
+// serveBatchRequest decodes raw, a single request of a batch or one passed to
+// a Dispatcher, and serves it with serveRequest. If co is non-nil, the handler
+// is called through it.
+func (s Server) serveBatchRequest(ctx context.Context, header http.Header, raw json.RawMessage, co *coalescer) reply {
+	var req Request
+	if json.Unmarshal(raw, &req) != nil {
+		err := invalidRequestError{}
+		s.logger.Log("err", err)
+		s.auditRequest(ctx, req, err)
+		return reply{ctx: ctx, res: newResponse(ctx, nil, localizedError(ctx, err)), err: err}
+	}
+	if co == nil {
+		return s.serveRequest(ctx, header, req, nil)
+	}
+	return s.serveRequest(ctx, header, req, func(ctx context.Context, req Request, h Handler) (json.RawMessage, http.Header, error) {
+		return co.do(req, func() (json.RawMessage, http.Header, error) { return h.ServeJSONRPC(ctx, header, req.Params) })
+	})
+}
+
+// BatchCoalesce makes the server serve the requests of a batch that keyFn
+// maps to the same non-empty key only once, and share the result, or error,
+// between them; each request is still answered with its own id. It's meant
+// for reads, which clients may repeat within a batch. Requests with an empty
+// key are always served. If the handler serving a key panics, the panic is
+// logged to the server's error logger and all the requests with that key are
+// answered with InternalError, rather than leaving those waiting for it
+// blocked. By default, every request is served.
+func BatchCoalesce(keyFn func(Request) string) ServerOption {
+	return func(s *Server) { s.coalesce = keyFn }
+}
+
+// coalescer shares the outcome of the requests of a batch with the same key.
+type coalescer struct {
+	key    func(Request) string
+	logger log.Logger
+	mtx    sync.Mutex
+	calls  map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done   chan struct{}
+	result json.RawMessage
+	rh     http.Header
+	err    error
+}
+
+// do calls serve for the first request with the key of req, and waits for
+// the outcome of that call for the subsequent ones. A panic of serve is
+// recovered and shared as an InternalError.
+func (c *coalescer) do(req Request, serve func() (json.RawMessage, http.Header, error)) (result json.RawMessage, rh http.Header, err error) {
+	key := c.key(req)
+	if key == "" {
+		return serve()
+	}
+	c.mtx.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mtx.Unlock()
+		<-call.done
+		return call.result, call.rh, call.err
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mtx.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Log("err", fmt.Sprintf("panic: %v", r), "stack", string(debug.Stack()))
+			call.result, call.rh, call.err = nil, nil, internalError{fmt.Errorf("panic: %v", r)}
+		}
+		close(call.done)
+		result, rh, err = call.result, call.rh, call.err
+	}()
+	call.result, call.rh, call.err = serve()
+	return
+}
@@ -0,0 +1,354 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestBatch(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	resp := post(t, handler, ` [
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},
+		{"jsonrpc":"2.0","method":"sub","params":[1,2],"id":2},
+		1,
+		{"jsonrpc":"2.0","method":"add","params":[3,4],"id":3}
+	]`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 4, len(res); want != have {
+		t.Fatalf("want %d results, have %d", want, have)
+	}
+	if want, have := "3", string(res[0].Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := jsonrpc.MethodNotFoundError, errorCode(t, res[1]); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, res[2]); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "7", string(res[3].Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestBatchEmpty(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	res := decodeResponse(t, post(t, handler, `[]`))
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestBatchInvalidJSON(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	res := decodeResponse(t, post(t, handler, `[{"jsonrpc":"2.0","method":"add","params":[1,2]},{"jsonrpc"`))
+	if want, have := jsonrpc.ParseError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestBatchInvalidJSONAfterResponse(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	resp := post(t, handler, `[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},{"jsonrpc"`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res); want != have {
+		t.Fatalf("want %d results, have %d", want, have)
+	}
+	if want, have := "3", string(res[0].Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestBatchCoalesce(t *testing.T) {
+	var calls int32
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+			atomic.AddInt32(&calls, 1)
+			return ctx
+		}))},
+		jsonrpc.BatchConcurrency(4),
+		jsonrpc.BatchCoalesce(func(req jsonrpc.Request) string { return req.Method + string(req.Params) }),
+	)
+	resp := post(t, handler, `[
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":2},
+		{"jsonrpc":"2.0","method":"add","params":[3,4],"id":3},
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":4}
+	]`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := int32(2), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("want %d calls, have %d", want, have)
+	}
+	if want, have := 4, len(res); want != have {
+		t.Fatalf("want %d results, have %d", want, have)
+	}
+	for i, want := range []string{"3", "3", "7", "3"} {
+		if have := string(res[i].Result); want != have {
+			t.Errorf("result %d: want %s, have %s", i, want, have)
+		}
+		if want, have := fmt.Sprint(i+1), string(res[i].ID); want != have {
+			t.Errorf("result %d: want id %s, have %s", i, want, have)
+		}
+	}
+}
+
+func TestBatchServesRequestsAsTheyAreRead(t *testing.T) {
+	served := make(chan struct{}, 1)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceAfter(func(ctx context.Context, _ http.Header) context.Context {
+			served <- struct{}{}
+			return ctx
+		})),
+	})
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	rec := httptest.NewRecorder()
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", pr))
+	}()
+
+	io.WriteString(pw, `[{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]},`)
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("first request not served before the batch was complete")
+	}
+	io.WriteString(pw, `{"jsonrpc":"2.0","id":1,"method":"add","params":[3,4]}]`)
+	pw.Close()
+	<-served
+	<-done
+
+	var res []jsonrpc.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(res); want != have {
+		t.Errorf("want %d results, have %d", want, have)
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	var (
+		inFlight, peak int32
+		release        = make(chan struct{})
+	)
+	slow := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-release
+			return "ok", nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"slow": slow}, jsonrpc.BatchConcurrency(3))
+
+	go func() {
+		for atomic.LoadInt32(&inFlight) < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+	body := "[" + strings.TrimSuffix(strings.Repeat(`{"jsonrpc":"2.0","id":1,"method":"slow"},`, 6), ",") + "]"
+	resp := post(t, handler, body)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 6, len(res); want != have {
+		t.Errorf("want %d results, have %d", want, have)
+	}
+	if want, have := int32(3), atomic.LoadInt32(&peak); want != have {
+		t.Errorf("want peak concurrency %d, have %d", want, have)
+	}
+}
+
+// BenchmarkBatch compares the peak heap of serving a large batch as it's
+// decoded with that of the baseline decoding the whole batch before serving
+// it.
+func BenchmarkBatch(b *testing.B) {
+	server := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}, jsonrpc.BatchConcurrency(8))
+	var body strings.Builder
+	body.WriteString("[")
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"jsonrpc":"2.0","method":"add","params":[%d,1],"id":%d}`, i, i)
+	}
+	body.WriteString("]")
+
+	b.Run("streaming", func(b *testing.B) {
+		reportPeakHeap(b, func() {
+			rec := httptest.NewRecorder()
+			rec.Body = nil // discard the response
+			server.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body.String())))
+		})
+	})
+	b.Run("buffered", func(b *testing.B) {
+		d := server.Dispatcher()
+		reportPeakHeap(b, func() {
+			var batch []json.RawMessage
+			if err := json.NewDecoder(strings.NewReader(body.String())).Decode(&batch); err != nil {
+				b.Fatal(err)
+			}
+			responses := make([]jsonrpc.Response, len(batch))
+			for i, raw := range batch {
+				responses[i] = d.Dispatch(context.Background(), raw)
+			}
+			json.NewEncoder(ioutil.Discard).Encode(responses)
+		})
+	})
+}
+
+// reportPeakHeap runs f b.N times, and reports its allocations and the
+// largest growth of the heap in use seen, by sampling it, while f ran.
+func reportPeakHeap(b *testing.B, f func()) {
+	b.ReportAllocs()
+	var (
+		ms      runtime.MemStats
+		peak    uint64
+		done    = make(chan struct{})
+		sampled = make(chan struct{})
+	)
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapInuse
+	go func() {
+		defer close(sampled)
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > peak {
+				peak = ms.HeapInuse
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f()
+	}
+	b.StopTimer()
+	close(done)
+	<-sampled
+	if peak < base {
+		peak = base
+	}
+	b.ReportMetric(float64(peak-base), "peak-heap-B")
+}
+
+func TestBatchErrorAggregator(t *testing.T) {
+	var (
+		calls int
+		errs  []error
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.BatchConcurrency(4),
+		jsonrpc.BatchErrorAggregator(func(_ context.Context, e []error) {
+			calls++
+			errs = e
+		}),
+	)
+	resp := post(t, handler, `[
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},
+		{"jsonrpc":"2.0","method":"sub","params":[1,2],"id":2},
+		{"jsonrpc":"2.0","method":"add","params":"x","id":3},
+		{"jsonrpc":"2.0","method":"add","params":[3,4],"id":4}
+	]`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 4, len(res); want != have {
+		t.Fatalf("want %d results, have %d", want, have)
+	}
+	if res[1].Error == nil || res[2].Error == nil {
+		t.Errorf("want error objects for failed requests, have %+v", res)
+	}
+	if want, have := 1, calls; want != have {
+		t.Fatalf("want %d aggregator call, have %d", want, have)
+	}
+	if want, have := 2, len(errs); want != have {
+		t.Fatalf("want %d errors, have %d: %v", want, have, errs)
+	}
+	if want, have := jsonrpc.MethodNotFoundError, errs[0].(jsonrpc.ErrorCoder).ErrorCode(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestBatchCoalescePanic(t *testing.T) {
+	entered := make(chan struct{})
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"boom": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				<-entered // let the other request wait for this one
+				panic("boom")
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		)},
+		jsonrpc.BatchConcurrency(2),
+		jsonrpc.BatchCoalesce(func(req jsonrpc.Request) string {
+			if req.ID != nil && string(req.ID) == "2" {
+				close(entered)
+			}
+			return req.Method
+		}),
+	)
+	resp := post(t, handler, `[{"jsonrpc":"2.0","method":"boom","id":1},{"jsonrpc":"2.0","method":"boom","id":2}]`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(res); want != have {
+		t.Fatalf("want %d results, have %d", want, have)
+	}
+	for i := range res {
+		if want, have := jsonrpc.InternalError, errorCode(t, res[i]); want != have {
+			t.Errorf("result %d: want %d, have %d", i, want, have)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package jsonrpc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Cache stores results of client calls for ClientCache. Implementations must
+// be safe for concurrent use.
+type Cache interface {
+	// Get returns the result stored under key, if it hasn't expired.
+	Get(key string) (result json.RawMessage, ok bool)
+
+	// Set stores result under key until ttl has elapsed.
+	Set(key string, result json.RawMessage, ttl time.Duration)
+}
+
+// ClientCache makes the client cache the results of successful calls for
+// ttl, keyed by method and params, and answer identical calls from store
+// without sending them to the server until then. The ClientBefore and
+// ClientAfter functions aren't applied to cached calls. Errors are never
+// cached. If store is nil, a MemoryCache is used. By default, nothing is
+// cached.
+func ClientCache(ttl time.Duration, store Cache) ClientOption {
+	if store == nil {
+		store = NewMemoryCache()
+	}
+	return func(c *Client) {
+		c.cache = store
+		c.cacheTTL = ttl
+	}
+}
+
+// cacheKey returns the key of a call of method with params.
+func cacheKey(method string, params json.RawMessage) string {
+	sum := sha256.Sum256(params)
+	return method + ":" + hex.EncodeToString(sum[:])
+}
+
+// MemoryCache is an in-memory Cache. Expired results are dropped as they're
+// looked up, and swept by Set whenever the number of entries has doubled
+// since the last sweep, so that results that are never looked up again don't
+// pile up; the cost of sweeping is thus spread over the calls to Set.
+type MemoryCache struct {
+	mtx     sync.Mutex
+	entries map[string]cacheEntry
+	sweepAt int // the number of entries that triggers the next sweep
+}
+
+type cacheEntry struct {
+	result  json.RawMessage
+	expires time.Time
+}
+
+// minSweep is the least number of entries a MemoryCache sweeps at.
+const minSweep = 64
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string]cacheEntry{}, sweepAt: minSweep}
+}
+
+// Get implements Cache.
+func (c *MemoryCache) Get(key string) (json.RawMessage, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.result, true
+}
+
+// Set implements Cache.
+func (c *MemoryCache) Set(key string, result json.RawMessage, ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := time.Now()
+	c.entries[key] = cacheEntry{result: result, expires: now.Add(ttl)}
+	if len(c.entries) < c.sweepAt {
+		return
+	}
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.sweepAt = 2 * len(c.entries)
+	if c.sweepAt < minSweep {
+		c.sweepAt = minSweep
+	}
+}
+
+// Len returns the number of entries in the cache, including expired ones
+// that haven't been dropped yet.
+func (c *MemoryCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,99 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestClientCache(t *testing.T) {
+	var calls int
+	service := jsonrpc.NewService(
+		func(_ context.Context, request interface{}) (interface{}, error) {
+			calls++
+			if request.(string) == "missing" {
+				return nil, errors.New("not found")
+			}
+			return "hello " + request.(string), nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var name string
+			err := json.Unmarshal(params, &name)
+			return name, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{"greet": service}))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+	c := jsonrpc.NewClient(tgt, "greet", jsonrpc.ClientCache(time.Minute, nil))
+
+	for i := 0; i < 2; i++ {
+		var have string
+		if err := c.Call(context.Background(), "greet", "bob", &have); err != nil {
+			t.Fatal(err)
+		}
+		if want := "hello bob"; want != have {
+			t.Errorf("call %d: want %q, have %q", i, want, have)
+		}
+	}
+	if want, have := 1, calls; want != have {
+		t.Errorf("want %d server calls, have %d", want, have)
+	}
+
+	c.Call(context.Background(), "greet", "alice", nil)
+	if want, have := 2, calls; want != have {
+		t.Errorf("want %d server calls for other params, have %d", want, have)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.Call(context.Background(), "greet", "missing", nil); err == nil {
+			t.Errorf("call %d: want error, have none", i)
+		}
+	}
+	if want, have := 4, calls; want != have {
+		t.Errorf("want errors not cached, %d server calls, have %d", want, have)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	c := jsonrpc.NewMemoryCache()
+	c.Set("k", json.RawMessage(`1`), 10*time.Millisecond)
+	if result, ok := c.Get("k"); !ok || string(result) != "1" {
+		t.Errorf("want 1, have %s, %v", result, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("k"); ok {
+		t.Error("want expired entry gone")
+	}
+}
+
+func TestMemoryCacheSweep(t *testing.T) {
+	c := jsonrpc.NewMemoryCache()
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprint("old", i), json.RawMessage(`1`), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	peak := c.Len()
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprint("new", i), json.RawMessage(`1`), time.Minute)
+	}
+	if have := c.Len(); have >= peak+1000 {
+		t.Errorf("want expired entries swept, have %d entries", have)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := c.Get(fmt.Sprint("new", i)); !ok {
+			t.Fatalf("want live entry %d kept", i)
+		}
+	}
+	if want, have := 1000, c.Len(); want != have {
+		t.Errorf("want %d entries, have %d", want, have)
+	}
+}
@@ -0,0 +1,399 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Client calls the methods of a JSON-RPC server, and provides an Endpoint
+// for one of them.
+type Client struct {
+	client *http.Client
+	tgt    *url.URL
+	method string
+	enc    EncodeRequestFunc
+	dec    DecodeResponseFunc
+	before []RequestFunc
+	after  []ClientResponseFunc
+	id     *uint64
+
+	cache    Cache
+	cacheTTL time.Duration
+
+	latency metrics.Histogram
+	errs    metrics.Counter
+
+	tracer opentracing.Tracer
+
+	// sm is the ServiceMap of in-memory clients, whose calls are served by
+	// its handlers rather than sent over HTTP.
+	sm            ServiceMap
+	roundTrip     bool
+	serverOptions []ServerOption
+}
+
+// NewClient constructs a usable Client for the JSON-RPC server at tgt, whose
+// Endpoint calls method. Each request gets an id of its own, counting up
+// from 1.
+func NewClient(tgt *url.URL, method string, options ...ClientOption) *Client {
+	c := &Client{
+		client: http.DefaultClient,
+		tgt:    tgt,
+		method: method,
+		enc:    defaultRequestEncoder,
+		dec:    defaultResponseDecoder,
+		id:     new(uint64),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// InMemoryClient constructs a Client whose calls are served by the handlers
+// of sm, without going over the network, and whose Endpoint calls method. It's
+// intended for tests. By default, the params are passed straight to the
+// handler, skipping the encoding of the request and response envelopes and
+// everything the server does around the handler apart from the request and
+// response headers; see InMemoryRoundTrip.
+func InMemoryClient(sm ServiceMap, method string, options ...ClientOption) *Client {
+	c := &Client{
+		method: method,
+		enc:    defaultRequestEncoder,
+		dec:    defaultResponseDecoder,
+		id:     new(uint64),
+		sm:     sm,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.roundTrip {
+		c.client = &http.Client{Transport: handlerTransport{NewServer(c.sm, c.serverOptions...)}}
+		c.tgt = &url.URL{Scheme: "http", Host: "in-memory"}
+		c.sm = nil
+	}
+	return c
+}
+
+// ClientOption sets an optional parameter for clients.
+type ClientOption func(*Client)
+
+// SetClient sets the underlying HTTP client used for requests.
+// By default, http.DefaultClient is used.
+func SetClient(client *http.Client) ClientOption {
+	return func(c *Client) { c.client = client }
+}
+
+// ClientRequestEncoder sets the function encoding the requests passed to the
+// Endpoint into params. By default, requests are encoded as JSON.
+func ClientRequestEncoder(enc EncodeRequestFunc) ClientOption {
+	return func(c *Client) { c.enc = enc }
+}
+
+// ClientResponseDecoder sets the function decoding results into the responses
+// returned by the Endpoint. By default, the result is returned as a
+// json.RawMessage.
+func ClientResponseDecoder(dec DecodeResponseFunc) ClientOption {
+	return func(c *Client) { c.dec = dec }
+}
+
+// ClientBefore sets the RequestFuncs that are applied to the headers of the
+// outgoing HTTP request before it's sent.
+func ClientBefore(before ...RequestFunc) ClientOption {
+	return func(c *Client) { c.before = append(c.before, before...) }
+}
+
+// ClientAfter sets the ClientResponseFuncs applied to the headers of the
+// incoming HTTP response prior to the result being decoded.
+func ClientAfter(after ...ClientResponseFunc) ClientOption {
+	return func(c *Client) { c.after = append(c.after, after...) }
+}
+
+// ClientInstrumenting observes the seconds each call to the server took with
+// latency, labeled with "method", the method called, and counts failed calls
+// with errs, labeled with "method" and "error", which is "jsonrpc" for calls
+// answered with an error object and "transport" for calls that got no valid
+// response, e.g. because the server couldn't be reached. Calls answered from
+// the ClientCache aren't observed. Either may be nil.
+func ClientInstrumenting(latency metrics.Histogram, errs metrics.Counter) ClientOption {
+	return func(c *Client) {
+		c.latency = latency
+		c.errs = errs
+	}
+}
+
+// InMemoryRoundTrip makes an in-memory client encode each call as an HTTP
+// request, and serve it with a Server built from its ServiceMap and the given
+// options, as if it were sent to a real server. That's slower than calling
+// the handlers directly, but exercises the server options as well. It has no
+// effect on other clients.
+func InMemoryRoundTrip(options ...ServerOption) ClientOption {
+	return func(c *Client) {
+		c.roundTrip = true
+		c.serverOptions = options
+	}
+}
+
+// Endpoint returns a usable endpoint that invokes the method of the client.
+// An error object returned by the server is returned as an Error, which
+// implements ErrorCoder.
+func (c Client) Endpoint() endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		params, err := c.enc(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		ctx, result, err := c.call(ctx, c.method, params)
+		if err != nil {
+			return nil, err
+		}
+		return c.dec(ctx, result)
+	}
+}
+
+// Call calls method with the given params, which are encoded as JSON, and
+// decodes the result into result, which may be nil to discard it. If the
+// server answers with an error object, it's returned as an Error.
+func (c Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	_, res, err := c.call(ctx, method, raw)
+	if err != nil || result == nil {
+		return err
+	}
+	return json.Unmarshal(res, result)
+}
+
+// CallRaw calls method with the given params, which are encoded as JSON, and
+// returns the response envelope, with its result as raw JSON, along with the
+// headers of the response. An error object returned by the server is left in
+// the Error of the Response; the returned error is only set if no response
+// was received. Calls made with CallRaw bypass the ClientCache.
+func (c Client) CallRaw(ctx context.Context, method string, params interface{}) (Response, http.Header, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Response{}, nil, err
+	}
+	_, res, rh, err := c.callServer(ctx, method, raw)
+	return res, rh, err
+}
+
+// call calls method with the given params and returns its result, along with
+// the context returned by the ClientAfter functions.
+func (c Client) call(ctx context.Context, method string, params json.RawMessage) (context.Context, json.RawMessage, error) {
+	if c.cache == nil {
+		return c.callResult(ctx, method, params)
+	}
+	key := cacheKey(method, params)
+	if result, ok := c.cache.Get(key); ok {
+		return ctx, result, nil
+	}
+	ctx, result, err := c.callResult(ctx, method, params)
+	if err == nil {
+		c.cache.Set(key, result, c.cacheTTL)
+	}
+	return ctx, result, err
+}
+
+// callResult calls method on the server, bypassing the ClientCache, and
+// returns its result, or its error object as an Error.
+func (c Client) callResult(ctx context.Context, method string, params json.RawMessage) (context.Context, json.RawMessage, error) {
+	ctx, res, _, err := c.callServer(ctx, method, params)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if res.Error != nil {
+		return ctx, nil, *res.Error
+	}
+	return ctx, res.Result, nil
+}
+
+// callServer calls method on the server, bypassing the ClientCache, and
+// returns its response and response headers, along with the context returned
+// by the ClientAfter functions.
+func (c Client) callServer(ctx context.Context, method string, params json.RawMessage) (context.Context, Response, http.Header, error) {
+	h := http.Header{}
+	for _, f := range c.before {
+		ctx = f(ctx, h)
+	}
+	c.injectSpan(ctx, h)
+
+	var (
+		res   Response
+		rh    http.Header
+		err   error
+		begin = time.Now()
+	)
+	if c.sm != nil {
+		res, rh = c.serve(ctx, method, params, h)
+	} else {
+		res, rh, err = c.post(ctx, method, params, h)
+	}
+	c.instrument(method, time.Since(begin), res, err)
+	if err != nil {
+		return ctx, Response{}, nil, err
+	}
+
+	for _, f := range c.after {
+		ctx = f(ctx, rh)
+	}
+	return ctx, res, rh, nil
+}
+
+// instrument records a call to method that took d, and yielded res or err,
+// with the metrics of ClientInstrumenting.
+func (c Client) instrument(method string, d time.Duration, res Response, err error) {
+	if c.latency != nil {
+		c.latency.With("method", method).Observe(d.Seconds())
+	}
+	if c.errs == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		c.errs.With("method", method, "error", "transport").Add(1)
+	case res.Error != nil:
+		c.errs.With("method", method, "error", "jsonrpc").Add(1)
+	}
+}
+
+// serve serves a call of an in-memory client with the handler of method.
+func (c Client) serve(ctx context.Context, method string, params json.RawMessage, h http.Header) (Response, http.Header) {
+	handler, ok := c.sm[method]
+	if !ok {
+		return Response{Error: ToJSONRPCError(methodNotFoundError{method})}, http.Header{}
+	}
+	result, rh, err := handler.ServeJSONRPC(ctx, h, params)
+	if rh == nil {
+		rh = http.Header{}
+	}
+	if err != nil {
+		return Response{Error: ToJSONRPCError(err)}, rh
+	}
+	return Response{Result: result}, rh
+}
+
+// post sends a call to the server and decodes its response.
+func (c Client) post(ctx context.Context, method string, params json.RawMessage, h http.Header) (Response, http.Header, error) {
+	id := json.RawMessage(strconv.FormatUint(atomic.AddUint64(c.id, 1), 10))
+	body, err := json.Marshal(Request{JSONRPC: Version, Method: method, Params: params, ID: id})
+	if err != nil {
+		return Response{}, nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.tgt.String(), bytes.NewReader(body))
+	if err != nil {
+		return Response{}, nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Response{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return Response{}, nil, fmt.Errorf("jsonrpc: unexpected HTTP status %s", resp.Status)
+		}
+		return Response{}, nil, err
+	}
+	if res.Error == nil && !bytes.Equal(res.ID, id) {
+		return Response{}, nil, fmt.Errorf("jsonrpc: response id %s doesn't match request id %s", res.ID, id)
+	}
+	return res, resp.Header, nil
+}
+
+func defaultRequestEncoder(_ context.Context, request interface{}) (json.RawMessage, error) {
+	return json.Marshal(request)
+}
+
+func defaultResponseDecoder(_ context.Context, result json.RawMessage) (interface{}, error) {
+	return result, nil
+}
+
+// handlerTransport is an http.RoundTripper serving requests with a handler.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := &responseBuffer{header: http.Header{}}
+	t.h.ServeHTTP(w, r)
+	return w.response(r), nil
+}
+
+// responseBuffer is an http.ResponseWriter keeping the response in memory.
+type responseBuffer struct {
+	header http.Header
+	sent   http.Header // the header as of WriteHeader
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header { return w.header }
+
+func (w *responseBuffer) WriteHeader(code int) {
+	if w.sent != nil {
+		return
+	}
+	w.code = code
+	w.sent = http.Header{}
+	for k, v := range w.header {
+		w.sent[k] = append([]string(nil), v...)
+	}
+}
+
+func (w *responseBuffer) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// response returns the buffered response to r. Values set for the trailers
+// declared in the Trailer header are returned as trailers.
+func (w *responseBuffer) response(r *http.Request) *http.Response {
+	w.WriteHeader(http.StatusOK)
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.code, http.StatusText(w.code)),
+		StatusCode:    w.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          ioutil.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       r,
+	}
+	for _, declared := range w.sent["Trailer"] {
+		for _, k := range strings.Split(declared, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if v, ok := w.header[k]; ok {
+				if resp.Trailer == nil {
+					resp.Trailer = http.Header{}
+				}
+				resp.Trailer[k] = v
+			}
+		}
+	}
+	return resp
+}
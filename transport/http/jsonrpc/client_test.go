@@ -0,0 +1,225 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestClientParity(t *testing.T) {
+	sm := jsonrpc.ServiceMap{"add": addService()}
+	server := httptest.NewServer(jsonrpc.NewServer(sm))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+
+	clients := map[string]*jsonrpc.Client{
+		"http":       jsonrpc.NewClient(tgt, "add"),
+		"in-memory":  jsonrpc.InMemoryClient(sm, "add"),
+		"round trip": jsonrpc.InMemoryClient(sm, "add", jsonrpc.InMemoryRoundTrip()),
+	}
+	for name, c := range clients {
+		t.Run(name, func(t *testing.T) {
+			var sum int
+			if err := c.Call(context.Background(), "add", []int{1, 2}, &sum); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := 3, sum; want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+
+			result, err := c.Endpoint()(context.Background(), []int{3, 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := "7", string(result.(json.RawMessage)); want != have {
+				t.Errorf("endpoint: want %s, have %s", want, have)
+			}
+
+			for _, tc := range []struct {
+				method string
+				params interface{}
+				code   int
+			}{
+				{"sub", []int{1, 2}, jsonrpc.MethodNotFoundError},
+				{"add", "x", jsonrpc.InternalError},
+			} {
+				err := c.Call(context.Background(), tc.method, tc.params, nil)
+				e, ok := err.(jsonrpc.Error)
+				if !ok {
+					t.Fatalf("%s(%v): want jsonrpc.Error, have %v", tc.method, tc.params, err)
+				}
+				if want, have := tc.code, e.ErrorCode(); want != have {
+					t.Errorf("%s(%v): want %d, have %d", tc.method, tc.params, want, have)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryRoundTripServerOptions(t *testing.T) {
+	sm := jsonrpc.ServiceMap{"add": addService()}
+	c := jsonrpc.InMemoryClient(sm, "add", jsonrpc.InMemoryRoundTrip(jsonrpc.ServerErroringBefore(
+		func(ctx context.Context, _ http.Header) (context.Context, error) {
+			return ctx, jsonrpc.Error{Code: jsonrpc.UnauthorizedError}
+		},
+	)))
+	err := c.Call(context.Background(), "add", []int{1, 2}, nil)
+	if e, ok := err.(jsonrpc.Error); !ok || e.Code != jsonrpc.UnauthorizedError {
+		t.Errorf("want Unauthorized, have %v", err)
+	}
+}
+
+func TestInMemoryRoundTripStream(t *testing.T) {
+	c := jsonrpc.InMemoryClient(jsonrpc.ServiceMap{"count": countService()}, "count", jsonrpc.InMemoryRoundTrip())
+	var elements []int
+	if err := c.Call(context.Background(), "count", 3, &elements); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []int{0, 1, 2}, elements; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestClientEndpoint(t *testing.T) {
+	var ids, users []string
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceAfter(func(ctx context.Context, h http.Header) context.Context {
+			h.Set("X-Trace", "abc")
+			return ctx
+		})),
+	}, jsonrpc.ServerBefore(func(ctx context.Context, h http.Header) context.Context {
+		users = append(users, h.Get("X-User"))
+		return ctx
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.Request
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		ids = append(ids, string(req.ID))
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+
+	type traceKey struct{}
+	c := jsonrpc.NewClient(tgt, "add",
+		jsonrpc.ClientRequestEncoder(func(_ context.Context, request interface{}) (json.RawMessage, error) {
+			pair := request.([2]int)
+			return json.Marshal([]int{pair[0], pair[1]})
+		}),
+		jsonrpc.ClientResponseDecoder(func(ctx context.Context, result json.RawMessage) (interface{}, error) {
+			if want, have := "abc", ctx.Value(traceKey{}); want != have {
+				t.Errorf("want trace %s, have %v", want, have)
+			}
+			var sum int
+			err := json.Unmarshal(result, &sum)
+			return sum, err
+		}),
+		jsonrpc.ClientBefore(func(ctx context.Context, h http.Header) context.Context {
+			h.Set("X-User", "alice")
+			return ctx
+		}),
+		jsonrpc.ClientAfter(func(ctx context.Context, h http.Header) context.Context {
+			return context.WithValue(ctx, traceKey{}, h.Get("X-Trace"))
+		}),
+	)
+	e := c.Endpoint()
+	for _, want := range []int{3, 7} {
+		response, err := e(context.Background(), [2]int{want - 2, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := response.(int); want != have {
+			t.Errorf("want %d, have %d", want, have)
+		}
+	}
+	if want, have := []string{"1", "2"}, ids; !reflect.DeepEqual(want, have) {
+		t.Errorf("want ids %v, have %v", want, have)
+	}
+	if want, have := []string{"alice", "alice"}, users; !reflect.DeepEqual(want, have) {
+		t.Errorf("want users %v, have %v", want, have)
+	}
+
+	_, err := jsonrpc.NewClient(tgt, "sub").Endpoint()(context.Background(), []int{1, 2})
+	ec, ok := err.(jsonrpc.ErrorCoder)
+	if !ok {
+		t.Fatalf("want an ErrorCoder, have %v", err)
+	}
+	if want, have := jsonrpc.MethodNotFoundError, ec.ErrorCode(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestClientCallRaw(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceAfter(func(ctx context.Context, h http.Header) context.Context {
+			h.Set("X-Trace", "abc")
+			return ctx
+		})),
+	}))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+	c := jsonrpc.NewClient(tgt, "add")
+
+	res, h, err := c.CallRaw(context.Background(), "add", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want result %s, have %s", want, have)
+	}
+	if want, have := "1", string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+	if want, have := "abc", h.Get("X-Trace"); want != have {
+		t.Errorf("want header %q, have %q", want, have)
+	}
+
+	res, _, err = c.CallRaw(context.Background(), "sub", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error == nil || res.Error.Code != jsonrpc.MethodNotFoundError {
+		t.Errorf("want MethodNotFound error object, have %v", res.Error)
+	}
+	if res.Result != nil {
+		t.Errorf("want no result, have %s", res.Result)
+	}
+}
+
+func TestClientInstrumenting(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}))
+	tgt, _ := url.Parse(server.URL)
+	var (
+		h    = &histogram{}
+		errs = &counter{counts: map[string]float64{}}
+		c    = jsonrpc.NewClient(tgt, "add", jsonrpc.ClientInstrumenting(h, errs))
+	)
+	c.Call(context.Background(), "add", []int{1, 2}, nil)
+	c.Call(context.Background(), "sub", []int{1, 2}, nil)
+	server.Close()
+	c.Call(context.Background(), "add", []int{1, 2}, nil)
+
+	want := map[string]float64{"method,sub,error,jsonrpc": 1, "method,add,error,transport": 1}
+	if !reflect.DeepEqual(want, errs.counts) {
+		t.Errorf("want %v, have %v", want, errs.counts)
+	}
+	if want, have := 3, len(h.observations); want != have {
+		t.Errorf("want %d observations, have %d", want, have)
+	}
+	if want, have := []string{"method", "add", "method", "sub", "method", "add"}, h.labelValues; !reflect.DeepEqual(want, have) {
+		t.Errorf("want labels %v, have %v", want, have)
+	}
+}
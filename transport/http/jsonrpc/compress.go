@@ -0,0 +1,95 @@
+package jsonrpc
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errMalformedGzip = errors.New("malformed gzip body")
+
+// CompressionMinSize makes the server gzip responses larger than n bytes for
+// clients that accept it, as told by their Accept-Encoding header. Smaller
+// responses are sent uncompressed, as compressing them costs more CPU than it
+// saves bandwidth. Streamed results aren't compressed, so that they can be
+// flushed element by element. By default, no response is compressed.
+func CompressionMinSize(n int) ServerOption {
+	return func(s *Server) {
+		s.compress = true
+		s.compressMin = n
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header h allows a gzipped
+// response.
+func acceptsGzip(h http.Header) bool {
+	for _, v := range h["Accept-Encoding"] {
+		for _, coding := range strings.Split(v, ",") {
+			params := strings.Split(coding, ";")
+			if strings.TrimSpace(params[0]) != "gzip" {
+				continue
+			}
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					q, err := strconv.ParseFloat(p[2:], 64)
+					return err == nil && q > 0
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// writeCompressed writes body to w, gzipping it if it's larger than the
+// threshold in ctx, which is only set for clients that accept gzip.
+func writeCompressed(ctx context.Context, w http.ResponseWriter, code int, body []byte) error {
+	min, ok := ctx.Value(contextKeyCompressMin).(int)
+	if !ok || len(body) <= min {
+		w.WriteHeader(code)
+		_, err := w.Write(body)
+		return err
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(code)
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// gunzipBody returns a reader decompressing the gzipped request body. A
+// failure to decompress it, including an invalid header, is reported as
+// errMalformedGzip.
+func gunzipBody(body io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := gzip.NewReader(body)
+	if err == errDecodeTimeout {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errMalformedGzip
+	}
+	return gunzipReader{zr, body}, nil
+}
+
+type gunzipReader struct {
+	zr   *gzip.Reader
+	body io.Closer
+}
+
+func (r gunzipReader) Read(p []byte) (int, error) {
+	n, err := r.zr.Read(p)
+	if err != nil && err != io.EOF && err != errDecodeTimeout {
+		err = errMalformedGzip
+	}
+	return n, err
+}
+
+func (r gunzipReader) Close() error { return r.body.Close() }
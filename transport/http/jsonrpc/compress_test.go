@@ -0,0 +1,123 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func repeatService() *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(_ context.Context, request interface{}) (interface{}, error) {
+			return strings.Repeat("x", request.(int)), nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var n int
+			err := json.Unmarshal(params, &n)
+			return n, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+	)
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"repeat": repeatService()},
+		jsonrpc.CompressionMinSize(1024),
+	)
+	gzipHeader := http.Header{"Accept-Encoding": {"gzip"}}
+
+	for _, tc := range []struct {
+		name       string
+		n          int
+		header     http.Header
+		compressed bool
+	}{
+		{"small", 10, gzipHeader, false},
+		{"large", 2048, gzipHeader, true},
+		{"large without gzip", 2048, http.Header{"Accept-Encoding": {"identity"}}, false},
+		{"large with gzip refused", 2048, http.Header{"Accept-Encoding": {"gzip;q=0, identity"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := postHeader(t, handler, `{"jsonrpc":"2.0","id":1,"method":"repeat","params":`+strconv.Itoa(tc.n)+`}`, tc.header)
+			defer resp.Body.Close()
+			if want, have := tc.compressed, resp.Header.Get("Content-Encoding") == "gzip"; want != have {
+				t.Fatalf("want compressed %v, have %v", want, have)
+			}
+			body := resp.Body
+			if tc.compressed {
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			var res jsonrpc.Response
+			if err := json.NewDecoder(body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := tc.n+2, len(res.Result); want != have {
+				t.Errorf("want result of %d bytes, have %d", want, have)
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestServerGzippedRequests(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.CompressionMinSize(0),
+	)
+	body := gzipped(t, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`)
+
+	resp := postHeader(t, handler, body, http.Header{"Content-Encoding": {"gzip"}, "Accept-Encoding": {"gzip"}})
+	defer resp.Body.Close()
+	if want, have := "gzip", resp.Header.Get("Content-Encoding"); want != have {
+		t.Fatalf("want Content-Encoding %q, have %q", want, have)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res jsonrpc.Response
+	if err := json.NewDecoder(zr).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	for name, body := range map[string]string{
+		"not gzip":  `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`,
+		"truncated": body[:len(body)/2],
+		"corrupt":   body[:12] + strings.Repeat("\xff", len(body)-12),
+	} {
+		res := decodeResponse(t, postHeader(t, handler, body, http.Header{"Content-Encoding": {"gzip"}}))
+		if want, have := jsonrpc.ParseError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", name, want, have)
+		}
+	}
+}
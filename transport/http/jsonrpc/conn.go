@@ -0,0 +1,215 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// CancelRequestMethod is the method of the notifications canceling a request
+// in the Language Server Protocol, for use with CancelNotifications.
+const CancelRequestMethod = "$/cancelRequest"
+
+// CancelNotifications lets the clients of NewWebsocketServer and Peer cancel
+// a request they sent on the same connection, which is still being served,
+// with a notification of method, e.g. CancelRequestMethod, whose params hold
+// the id of the request, as in {"id":1}. The context of the request is then
+// canceled, and if the handler fails, the request is answered with
+// RequestCanceledError. Notifications for unknown ids are ignored, and only
+// requests sent on their own, rather than in a batch, can be canceled. It has
+// no effect on HTTP requests. By default, method is served like any other.
+func CancelNotifications(method string) ServerOption {
+	return func(s *Server) { s.cancelMethod = method }
+}
+
+// PerConnectionRateLimit limits the rate of requests each connection of
+// NewWebsocketServer and Peer may send to limit, with bursts of up to burst
+// requests, so that a single noisy client can't flood the server. A batch
+// counts as one request. Requests beyond the limit aren't served: they're
+// answered with RateLimitedError, excess batches with a single error response,
+// and excess notifications are dropped. Cancel notifications aren't limited.
+// It has no effect on HTTP requests; see ServiceRateLimit. By default,
+// connections aren't limited.
+func PerConnectionRateLimit(limit rate.Limit, burst int) ServerOption {
+	return func(s *Server) {
+		s.connLimit = limit
+		s.connBurst = burst
+	}
+}
+
+// MaxPendingRequests limits the requests and batches each connection of
+// NewWebsocketServer and Peer may have pending, i.e. received but not yet
+// answered, to n, so that a client that keeps sending to slow endpoints can't
+// exhaust the server's memory. Requests beyond the limit aren't served:
+// they're answered with ServerBusyError, excess batches with a single error
+// response, and excess notifications are dropped. Cancel notifications aren't
+// limited. It has no effect on HTTP requests; see ServiceMaxConcurrent. By
+// default, pending requests aren't limited.
+func MaxPendingRequests(n int) ServerOption {
+	return func(s *Server) { s.maxPending = n }
+}
+
+// errRequestCanceled is the cause of the cancellation of requests canceled by
+// their client.
+var errRequestCanceled = errors.New("request canceled by client")
+
+// conn serves the requests and batches read from a persistent connection,
+// such as a WebSocket or the stream of a Peer, each in a goroutine of its
+// own, and sends their responses with send.
+type conn struct {
+	d    *Dispatcher
+	send func(v interface{}) error
+	wg   sync.WaitGroup
+
+	limiter *rate.Limiter
+
+	mtx      sync.Mutex
+	inFlight map[string]context.CancelCauseFunc
+	pending  int
+}
+
+func newConn(d *Dispatcher, send func(v interface{}) error) *conn {
+	c := &conn{
+		d:        d,
+		send:     send,
+		inFlight: map[string]context.CancelCauseFunc{},
+	}
+	if d.s.connBurst > 0 {
+		c.limiter = rate.NewLimiter(d.s.connLimit, d.s.connBurst)
+	}
+	return c
+}
+
+// serve starts serving raw, a request or batch, unless the connection handles
+// it itself, as it does cancel notifications.
+func (c *conn) serve(ctx context.Context, raw json.RawMessage) {
+	var (
+		head   requestHead
+		single = !bytes.HasPrefix(raw, []byte("["))
+	)
+	if single {
+		json.Unmarshal(raw, &head) // invalid requests are left to the Dispatcher
+	}
+	if single && head.ID == nil && c.d.s.cancelMethod != "" && head.Method == c.d.s.cancelMethod {
+		c.cancel(head.Params)
+		return
+	}
+	if c.limiter != nil && !c.limiter.Allow() {
+		c.reject(ctx, head, single, rateLimitedError{})
+		return
+	}
+
+	c.mtx.Lock()
+	if max := c.d.s.maxPending; max > 0 && c.pending >= max {
+		c.mtx.Unlock()
+		c.reject(ctx, head, single, serverBusyError{})
+		return
+	}
+	c.pending++
+	ctx, cancel := context.WithCancelCause(ctx)
+	var key string
+	if single && head.ID != nil {
+		key = string(head.ID)
+		c.inFlight[key] = cancel
+	}
+	c.mtx.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		msg := c.dispatch(ctx, raw)
+		cancel(nil)
+		c.mtx.Lock()
+		c.pending--
+		if key != "" {
+			delete(c.inFlight, key)
+		}
+		c.mtx.Unlock()
+		if msg != nil {
+			c.send(msg)
+		}
+	}()
+}
+
+// requestHead holds the members of a request the connection looks at before
+// dispatching it.
+type requestHead struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// reject answers a request or batch that isn't served with err, as described
+// by head for a single request, unless it's a notification.
+func (c *conn) reject(ctx context.Context, head requestHead, single bool, err error) {
+	c.d.s.logger.Log("method", head.Method, "err", err)
+	if head.JSONRPC == "" && string(head.ID) == "null" {
+		head.ID = nil // a 1.0 notification
+	}
+	if single && head.ID == nil {
+		return
+	}
+	if head.ID == nil {
+		head.ID = json.RawMessage("null")
+	}
+	ctx = context.WithValue(c.d.s.withValues(ctx), contextKeyRequestID, head.ID)
+	if single && head.JSONRPC == "" {
+		ctx = context.WithValue(ctx, contextKeyVersion, Version1)
+	}
+	c.send(wireResponse(toResponse(newResponse(ctx, nil, localizedError(ctx, err)))))
+}
+
+// cancel cancels the request in flight whose id is given in the params of a
+// cancel notification.
+func (c *conn) cancel(params json.RawMessage) {
+	var p struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.ID == nil {
+		c.d.s.logger.Log("method", c.d.s.cancelMethod, "err", "want params with an id")
+		return
+	}
+	c.mtx.Lock()
+	cancel, ok := c.inFlight[string(p.ID)]
+	c.mtx.Unlock()
+	if ok {
+		cancel(errRequestCanceled)
+	}
+}
+
+// dispatch serves raw, a request or batch, and returns the message to send
+// in response, if any.
+func (c *conn) dispatch(ctx context.Context, raw json.RawMessage) interface{} {
+	if !bytes.HasPrefix(raw, []byte("[")) {
+		res := c.d.Dispatch(ctx, raw)
+		if res.ID == nil {
+			return nil
+		}
+		if res.Error != nil && context.Cause(ctx) == errRequestCanceled {
+			res.Error = localizedError(c.d.s.withValues(ctx), Error{
+				Code:    RequestCanceledError,
+				Message: errorMessage[RequestCanceledError],
+			})
+		}
+		return wireResponse(res)
+	}
+	responses := c.d.DispatchBatch(ctx, raw)
+	if len(responses) == 0 {
+		return nil
+	}
+	batch := make([]interface{}, len(responses))
+	for i, res := range responses {
+		batch[i] = wireResponse(res)
+	}
+	return batch
+}
+
+// wait waits for the requests being served to be answered.
+func (c *conn) wait() {
+	c.wg.Wait()
+}
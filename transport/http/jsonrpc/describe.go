@@ -0,0 +1,140 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// MethodDescriptor is the machine-readable description of a method, e.g. for
+// generating clients. The schemas are JSON Schemas, as given to the service.
+type MethodDescriptor struct {
+	Name         string          `json:"name"`
+	Description  string          `json:"description,omitempty"`
+	ParamsSchema json.RawMessage `json:"paramsSchema,omitempty"`
+	ResultSchema json.RawMessage `json:"resultSchema,omitempty"`
+	ErrorCodes   []int           `json:"errorCodes,omitempty"`
+}
+
+// ServiceDescription sets the human-readable description of the method,
+// reported by Server.Describe.
+func ServiceDescription(description string) ServiceOption {
+	return func(s *Service) { s.desc.Description = description }
+}
+
+// ServiceSchema sets the JSON Schemas of the params and result of the method,
+// reported by Server.Describe and OpenRPC. Each is either a json.RawMessage
+// holding the schema itself, or an example value, e.g. a zero struct, whose
+// Go type the schema is reflected from; see SchemaOf. Either may be nil if
+// unknown. The service doesn't enforce them.
+func ServiceSchema(params, result interface{}) ServiceOption {
+	return func(s *Service) {
+		s.desc.ParamsSchema = schemaFor(params)
+		s.desc.ResultSchema = schemaFor(result)
+	}
+}
+
+// schemaFor returns the schema given to ServiceSchema as a json.RawMessage.
+func schemaFor(v interface{}) json.RawMessage {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		return v
+	}
+	schema, _ := json.Marshal(SchemaOf(v))
+	return schema
+}
+
+// ServiceErrorCodes sets the JSON-RPC error codes the method may return,
+// reported by Server.Describe.
+func ServiceErrorCodes(codes ...int) ServiceOption {
+	return func(s *Service) { s.desc.ErrorCodes = codes }
+}
+
+// MethodInfo is static metadata about a method, which the server puts in the
+// context of each request for it before calling its handler, so that generic
+// middleware, e.g. for authorization, can make decisions without being wired
+// to each method.
+type MethodInfo struct {
+	// Name is the name of the method, as registered in the ServiceMap.
+	Name string
+
+	// Scopes are the scopes a caller needs to call the method.
+	Scopes []string
+
+	// Mutation is true if the method changes state, as opposed to reads.
+	Mutation bool
+
+	// Extra holds any other metadata, keyed by the middleware using it.
+	Extra map[string]interface{}
+}
+
+// ServiceMethodInfo sets the MethodInfo of the method; its Name is set by the
+// server. By default, the method has no MethodInfo.
+func ServiceMethodInfo(info MethodInfo) ServiceOption {
+	return func(s *Service) { s.info = &info }
+}
+
+// MethodInfoFromContext returns the MethodInfo of the method being served. It's
+// false when the method has none, or the request wasn't dispatched by a Server.
+func MethodInfoFromContext(ctx context.Context) (MethodInfo, bool) {
+	info, ok := ctx.Value(contextKeyMethodInfo).(MethodInfo)
+	return info, ok
+}
+
+// methodInfoer is implemented by handlers that may carry a MethodInfo.
+type methodInfoer interface {
+	methodInfo() *MethodInfo
+}
+
+func (s Service) methodInfo() *MethodInfo { return s.info }
+
+// describer is implemented by handlers that carry a description of their
+// method.
+type describer interface {
+	describe() MethodDescriptor
+}
+
+func (s Service) describe() MethodDescriptor { return s.desc }
+
+// Describe returns the descriptions of all methods served by the server,
+// sorted by name. Methods whose handlers carry no metadata are only named.
+func (s Server) Describe() []MethodDescriptor {
+	return describe(s.sm)
+}
+
+func describe(sm ServiceMap) []MethodDescriptor {
+	descs := make([]MethodDescriptor, 0, len(sm))
+	for name, h := range sm {
+		var d MethodDescriptor
+		if dh, ok := h.(describer); ok {
+			d = dh.describe()
+		}
+		d.Name = name
+		descs = append(descs, d)
+	}
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Name < descs[j].Name })
+	return descs
+}
+
+// IntrospectionMethod makes the server answer calls of method with the result
+// of Describe, as an array of MethodDescriptors. The method is listed by
+// Describe itself. By default, there's no introspection method.
+func IntrospectionMethod(method string) ServerOption {
+	return func(s *Server) { s.introspection = method }
+}
+
+// describeHandler is the Handler of the introspection method.
+type describeHandler struct {
+	sm ServiceMap
+}
+
+func (h describeHandler) ServeJSONRPC(context.Context, http.Header, json.RawMessage) (json.RawMessage, http.Header, error) {
+	result, err := json.Marshal(describe(h.sm))
+	if err != nil {
+		return nil, nil, internalError{err}
+	}
+	return result, nil, nil
+}
@@ -0,0 +1,111 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestServerDescribe(t *testing.T) {
+	var (
+		params = json.RawMessage(`{"type":"array","items":{"type":"integer"}}`)
+		result = json.RawMessage(`{"type":"integer"}`)
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"add": addService(
+				jsonrpc.ServiceDescription("Adds two integers."),
+				jsonrpc.ServiceSchema(params, result),
+				jsonrpc.ServiceErrorCodes(jsonrpc.InvalidParamsError),
+			),
+			"count": countService(),
+		},
+		jsonrpc.IntrospectionMethod("rpc.describe"),
+	)
+
+	want := []jsonrpc.MethodDescriptor{
+		{
+			Name:         "add",
+			Description:  "Adds two integers.",
+			ParamsSchema: params,
+			ResultSchema: result,
+			ErrorCodes:   []int{jsonrpc.InvalidParamsError},
+		},
+		{Name: "count"},
+		{Name: "rpc.describe"},
+	}
+	if have := handler.Describe(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"rpc.describe","id":1}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	var have []jsonrpc.MethodDescriptor
+	if err := json.Unmarshal(res.Result, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+}
+
+func TestMethodInfoFromContext(t *testing.T) {
+	// requireScopes is generic middleware, guarding methods by their
+	// MethodInfo only.
+	granted := map[string]bool{"orders:read": true}
+	requireScopes := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			info, _ := jsonrpc.MethodInfoFromContext(ctx)
+			for _, scope := range info.Scopes {
+				if !granted[scope] {
+					return nil, jsonrpc.Error{Code: jsonrpc.UnauthorizedError, Message: info.Name + " needs " + scope}
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+	var seen []jsonrpc.MethodInfo
+	service := func(info jsonrpc.MethodInfo) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			requireScopes(func(ctx context.Context, _ interface{}) (interface{}, error) {
+				info, _ := jsonrpc.MethodInfoFromContext(ctx)
+				seen = append(seen, info)
+				return "ok", nil
+			}),
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceMethodInfo(info),
+		)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"orders.list":   service(jsonrpc.MethodInfo{Scopes: []string{"orders:read"}}),
+		"orders.cancel": service(jsonrpc.MethodInfo{Scopes: []string{"orders:write"}, Mutation: true}),
+	})
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"orders.list","id":1}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	want := []jsonrpc.MethodInfo{{Name: "orders.list", Scopes: []string{"orders:read"}}}
+	if !reflect.DeepEqual(want, seen) {
+		t.Errorf("want %+v, have %+v", want, seen)
+	}
+
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"orders.cancel","id":2}`))
+	if want, have := jsonrpc.UnauthorizedError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "orders.cancel needs orders:write", res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if _, ok := jsonrpc.MethodInfoFromContext(context.Background()); ok {
+		t.Error("want no MethodInfo outside a server")
+	}
+}
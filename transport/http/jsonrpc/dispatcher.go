@@ -0,0 +1,108 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Dispatcher serves JSON-RPC requests with the handlers of a ServiceMap,
+// independently of the transport they arrive on, e.g. a WebSocket or stdio.
+// It applies the same rules as a Server built with the same options: the
+// accepted versions and ids, RequestRewriter, the result checks, auditing,
+// error localization and so on. Options that concern HTTP requests only, such
+// as ServerBefore, the error encoder or the limits on request bodies, have no
+// effect. Handlers are passed the headers returned by RequestHeader for the
+// context of the call, e.g. those of the request a WebSocket connection was
+// upgraded from, or empty headers if there are none.
+type Dispatcher struct {
+	s Server
+}
+
+// NewDispatcher constructs a Dispatcher for the handlers in sm.
+func NewDispatcher(sm ServiceMap, options ...ServerOption) *Dispatcher {
+	return &Dispatcher{*NewServer(sm, options...)}
+}
+
+// Dispatcher returns a Dispatcher sharing the ServiceMap and options of the
+// server, so that other transports serve requests like it does.
+func (s Server) Dispatcher() *Dispatcher {
+	return &Dispatcher{s}
+}
+
+// Dispatch serves the single request raw and returns its response. Invalid
+// JSON yields a ParseError. A notification yields the zero Response, whose ID
+// is nil, which mustn't be sent; every other response has an ID, which is null
+// if the request's id is unknown. Responses to JSON-RPC 1.0 requests have an
+// empty JSONRPC member, which should then be omitted.
+func (d *Dispatcher) Dispatch(ctx context.Context, raw json.RawMessage) Response {
+	ctx = d.s.withValues(ctx)
+	if err := json.Unmarshal(raw, new(json.RawMessage)); err != nil {
+		d.s.logger.Log("err", err)
+		return toResponse(newResponse(ctx, nil, localizedError(ctx, parseError{})))
+	}
+	return toResponse(d.s.serveBatchRequest(ctx, requestHeader(ctx), raw, nil).res)
+}
+
+// DispatchBatch serves the requests of the batch raw, i.e. an array, as a
+// Server does, and returns their responses in order, leaving out those of
+// notifications. A batch that isn't valid JSON, or is empty, yields a single
+// error response, as for a single request.
+func (d *Dispatcher) DispatchBatch(ctx context.Context, raw json.RawMessage) []Response {
+	ctx = d.s.withValues(ctx)
+	var responses []Response
+	n, err := d.s.serveBatchRequests(ctx, requestHeader(ctx), json.NewDecoder(bytes.NewReader(raw)), func(rep reply) {
+		if rep.res != nil {
+			responses = append(responses, toResponse(rep.res))
+		}
+	})
+	if err == nil && n == 0 {
+		err = invalidRequestError{}
+	}
+	if err != nil {
+		d.s.logger.Log("err", err)
+		if isParseError(err) {
+			err = parseError{}
+		} else {
+			err = invalidRequestError{}
+		}
+		return []Response{toResponse(newResponse(ctx, nil, localizedError(ctx, err)))}
+	}
+	return responses
+}
+
+// requestHeader returns the request headers in ctx, or empty headers if there
+// are none.
+func requestHeader(ctx context.Context) http.Header {
+	if h := RequestHeader(ctx); h != nil {
+		return h
+	}
+	return http.Header{}
+}
+
+// wireResponse returns the value to encode to send res, which omits the
+// jsonrpc member of JSON-RPC 1.0 responses.
+func wireResponse(res Response) interface{} {
+	if res.JSONRPC == "" {
+		return response1{Result: res.Result, Error: res.Error, ID: res.ID}
+	}
+	return res
+}
+
+// toResponse converts a response built by newResponse into a Response.
+func toResponse(res interface{}) Response {
+	var r Response
+	switch res := res.(type) {
+	case nil:
+		return Response{}
+	case Response:
+		r = res
+	case response1:
+		r = Response{ID: res.ID, Result: res.Result, Error: res.Error}
+	}
+	if r.ID == nil {
+		r.ID = json.RawMessage("null")
+	}
+	return r
+}
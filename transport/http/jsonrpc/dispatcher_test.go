@@ -0,0 +1,108 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestDispatcherDispatch(t *testing.T) {
+	d := jsonrpc.NewDispatcher(jsonrpc.ServiceMap{"add": addService()})
+	for _, tc := range []struct {
+		raw, id, result string
+		code            int
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`, "1", "3", 0},
+		{`{"jsonrpc":"2.0","id":"a","method":"sub","params":[1,2]}`, `"a"`, "", jsonrpc.MethodNotFoundError},
+		{`{"jsonrpc":"2.0","id":2,"method":`, "null", "", jsonrpc.ParseError},
+		{`{"jsonrpc":"2.0","id":3}`, "3", "", jsonrpc.InvalidRequestError},
+	} {
+		res := d.Dispatch(context.Background(), json.RawMessage(tc.raw))
+		if want, have := tc.id, string(res.ID); want != have {
+			t.Errorf("%s: want id %s, have %s", tc.raw, want, have)
+		}
+		if want, have := tc.result, string(res.Result); want != have {
+			t.Errorf("%s: want result %s, have %s", tc.raw, want, have)
+		}
+		var code int
+		if res.Error != nil {
+			code = res.Error.Code
+		}
+		if want, have := tc.code, code; want != have {
+			t.Errorf("%s: want code %d, have %d", tc.raw, want, have)
+		}
+	}
+
+	if res := d.Dispatch(context.Background(), json.RawMessage(`{"jsonrpc":"2.0","method":"add","params":[1,2]}`)); res.ID != nil {
+		t.Errorf("want the zero Response for a notification, have %+v", res)
+	}
+}
+
+func TestDispatcherDispatchBatch(t *testing.T) {
+	d := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}).Dispatcher()
+	responses := d.DispatchBatch(context.Background(), json.RawMessage(`[
+		{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]},
+		{"jsonrpc":"2.0","method":"add","params":[3,4]},
+		{"jsonrpc":"2.0","id":2,"method":"add","params":[5,6]}
+	]`))
+	if want, have := 2, len(responses); want != have {
+		t.Fatalf("want %d responses, have %d", want, have)
+	}
+	for i, want := range []string{"3", "11"} {
+		if have := string(responses[i].Result); want != have {
+			t.Errorf("response %d: want %s, have %s", i, want, have)
+		}
+	}
+
+	for raw, code := range map[string]int{`[]`: jsonrpc.InvalidRequestError, `[{"jsonrpc"`: jsonrpc.ParseError} {
+		responses := d.DispatchBatch(context.Background(), json.RawMessage(raw))
+		if len(responses) != 1 || responses[0].Error == nil || responses[0].Error.Code != code {
+			t.Errorf("%s: want a single error %d, have %+v", raw, code, responses)
+		}
+	}
+}
+
+func TestDispatcherMatchesServer(t *testing.T) {
+	var (
+		sm      = jsonrpc.ServiceMap{"add": addService()}
+		options = []jsonrpc.ServerOption{
+			jsonrpc.RequireIDType(jsonrpc.IntegerID),
+			jsonrpc.AcceptVersions(jsonrpc.Version, jsonrpc.Version1),
+			jsonrpc.RequestRewriter(func(_ context.Context, req jsonrpc.Request) (jsonrpc.Request, error) {
+				if req.Method == "plus" {
+					req.Method = "add"
+				}
+				return req, nil
+			}),
+		}
+		handler = jsonrpc.NewServer(sm, options...)
+		d       = jsonrpc.NewDispatcher(sm, options...)
+	)
+	for _, raw := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`,
+		`{"jsonrpc":"2.0","id":2,"method":"plus","params":[1,2]}`,
+		`{"jsonrpc":"2.0","id":"a","method":"add","params":[1,2]}`,
+		`{"jsonrpc":"2.0","id":3,"method":"sub","params":[1,2]}`,
+		`{"jsonrpc":"2.0","id":4}`,
+		`{"id":5,"method":"add","params":[1,2]}`,
+		`{"jsonrpc":"2.0","method":"add","params":[1,2]}`,
+		`{"jsonrpc":"2.0","method":"sub"}`,
+	} {
+		want := d.Dispatch(context.Background(), json.RawMessage(raw))
+		resp := post(t, handler, raw)
+		if want.ID == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("%s: want no response, have status %d", raw, resp.StatusCode)
+			}
+			continue
+		}
+		if have := decodeResponse(t, resp); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: Dispatcher answered %+v, Server %+v", raw, want, have)
+		}
+	}
+}
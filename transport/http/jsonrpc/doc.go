@@ -0,0 +1,5 @@
+// Package jsonrpc provides a JSON-RPC 2.0 binding for endpoints over HTTP.
+// Each JSON-RPC method is served by a Handler, typically a Service wrapping
+// an endpoint with its codecs, and methods are collected into a ServiceMap
+// which is exposed by a Server.
+package jsonrpc
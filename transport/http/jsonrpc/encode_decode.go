@@ -0,0 +1,411 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecodeRequestFunc extracts a user-domain request object from the params of
+// a JSON-RPC request. It's designed to be used in JSON-RPC services, for
+// server-side endpoints. One straightforward DecodeRequestFunc could be
+// something that JSON decodes the params to the concrete request type.
+type DecodeRequestFunc func(context.Context, json.RawMessage) (request interface{}, err error)
+
+// EncodeResponseFunc encodes the passed response object to the result of a
+// JSON-RPC response. It's designed to be used in JSON-RPC services, for
+// server-side endpoints. One straightforward EncodeResponseFunc could be
+// something that JSON encodes the object directly.
+type EncodeResponseFunc func(context.Context, interface{}) (response json.RawMessage, err error)
+
+// EncodeRequestFunc encodes the passed request object into the params of a
+// JSON-RPC request. It's designed to be used in JSON-RPC clients, for
+// client-side endpoints. One straightforward EncodeRequestFunc could be
+// something that JSON encodes the object directly.
+type EncodeRequestFunc func(context.Context, interface{}) (params json.RawMessage, err error)
+
+// DecodeResponseFunc extracts a user-domain response object from the result
+// of a JSON-RPC response. It's designed to be used in JSON-RPC clients, for
+// client-side endpoints. One straightforward DecodeResponseFunc could be
+// something that JSON decodes the result to the concrete response type.
+type DecodeResponseFunc func(context.Context, json.RawMessage) (response interface{}, err error)
+
+// DecodeNamedRaw returns a DecodeRequestFunc that splits object params into a
+// map[string]json.RawMessage, leaving each param to be decoded by the
+// endpoint as needed. Params that aren't a JSON object yield an
+// InvalidParamsError.
+func DecodeNamedRaw() DecodeRequestFunc {
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		if !bytes.HasPrefix(bytes.TrimSpace(params), []byte("{")) {
+			return nil, invalidParamsError{errors.New("params must be an object")}
+		}
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(params, &named); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		return named, nil
+	}
+}
+
+// ParamsToStruct returns a DecodeRequestFunc that decodes named params, i.e.
+// an object, into a new value of the struct type pointed to by target. Absent
+// or null params yield the zero value. Positional params, i.e. an array, are
+// rejected with an InvalidParamsError telling the client that the method takes
+// named params, and params of the wrong shape with one giving the cause.
+func ParamsToStruct(target interface{}) DecodeRequestFunc {
+	typ := reflect.TypeOf(target).Elem()
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		request := reflect.New(typ).Interface()
+		trimmed := bytes.TrimSpace(params)
+		switch {
+		case len(trimmed) == 0 || string(trimmed) == "null":
+			return request, nil
+		case trimmed[0] == '[':
+			return nil, invalidParamsError{errors.New("method takes named params, not positional ones")}
+		case trimmed[0] != '{':
+			return nil, invalidParamsError{errors.New("params must be an object")}
+		}
+		if err := json.Unmarshal(params, request); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		return request, nil
+	}
+}
+
+// PositionalParams returns a DecodeRequestFunc that decodes positional params,
+// i.e. an array, into a new value of the struct type pointed to by defaults,
+// one element per exported field, in order. The new value starts as a copy of
+// *defaults, so trailing params the client leaves out keep their default; e.g.
+// with defaults of &struct{ ID, Limit int }{Limit: 10}, both [1] and [1, 10]
+// yield an ID of 1 and a Limit of 10. Absent or null params yield the
+// defaults. More elements than fields, elements of the wrong type and named
+// params are rejected with an InvalidParamsError.
+func PositionalParams(defaults interface{}) DecodeRequestFunc {
+	v := reflect.ValueOf(defaults).Elem()
+	var fields []int
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		request := reflect.New(v.Type())
+		request.Elem().Set(v)
+		trimmed := bytes.TrimSpace(params)
+		switch {
+		case len(trimmed) == 0 || string(trimmed) == "null":
+			return request.Interface(), nil
+		case trimmed[0] == '{':
+			return nil, invalidParamsError{errors.New("method takes positional params, not named ones")}
+		case trimmed[0] != '[':
+			return nil, invalidParamsError{errors.New("params must be an array")}
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(params, &elems); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		if len(elems) > len(fields) {
+			return nil, invalidParamsError{fmt.Errorf("method takes at most %d params, got %d", len(fields), len(elems))}
+		}
+		for i, elem := range elems {
+			if err := json.Unmarshal(elem, request.Elem().Field(fields[i]).Addr().Interface()); err != nil {
+				return nil, invalidParamsError{fmt.Errorf("param %d: %v", i, err)}
+			}
+		}
+		return request.Interface(), nil
+	}
+}
+
+// RequireFields returns a DecodeRequestFunc that decodes object params into a
+// new value of the type pointed to by v, and checks that each of the named
+// params is present and not null, "", [] or {}. Fields may be named by their
+// Go name or, as they appear in the params, by their JSON name, taken from
+// their json tag as encoding/json does; params are matched to them ignoring
+// case, as encoding/json does too. Missing fields yield an InvalidParamsError
+// listing their JSON names in its Data.
+func RequireFields(v interface{}, fields ...string) DecodeRequestFunc {
+	typ := reflect.TypeOf(v).Elem()
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = paramName(typ, field)
+	}
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(params, &named); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		var missing []string
+		for _, key := range keys {
+			switch string(bytes.TrimSpace(lookupParam(named, key))) {
+			case "", "null", `""`, "[]", "{}":
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, Error{
+				Code:    InvalidParamsError,
+				Message: "missing required params",
+				Data:    missing,
+			}
+		}
+		request := reflect.New(typ).Interface()
+		if err := json.Unmarshal(params, request); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		return request, nil
+	}
+}
+
+// paramName returns the JSON name of the field of the struct type typ named
+// name, either by its Go name or its JSON name, or name itself if there's no
+// such field.
+func paramName(typ reflect.Type, name string) string {
+	if typ.Kind() != reflect.Struct {
+		return name
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if jsonName, ok := jsonFieldName(field); ok && (field.Name == name || jsonName == name) {
+			return jsonName
+		}
+	}
+	return name
+}
+
+// jsonFieldName returns the name under which encoding/json encodes field, and
+// false if it's skipped.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+	if tag == "-" {
+		return "", false
+	}
+	if tag == "" {
+		return field.Name, true
+	}
+	return tag, true
+}
+
+// lookupParam returns the param of named params whose name is key, preferring
+// an exact match over one ignoring case.
+func lookupParam(named map[string]json.RawMessage, key string) json.RawMessage {
+	if v, ok := named[key]; ok {
+		return v
+	}
+	for k, v := range named {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// DecodeForm returns a DecodeRequestFunc that decodes params given as an
+// object of strings, such as the query parameters of a GET request served
+// under AllowGET, into a new value of the struct type pointed to by v. Fields
+// are matched by their JSON names, and values are converted to the field's
+// type; values of fields that aren't strings, booleans or numbers are parsed
+// as JSON. Unknown params are ignored, and values that can't be converted
+// yield an InvalidParamsError.
+func DecodeForm(v interface{}) DecodeRequestFunc {
+	typ := reflect.TypeOf(v).Elem()
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		var form map[string]string
+		if err := json.Unmarshal(params, &form); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		object := map[string]json.RawMessage{}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			value, ok := form[name]
+			if !ok {
+				continue
+			}
+			raw, err := formValue(field.Type, value)
+			if err != nil {
+				return nil, invalidParamsError{errors.New(name + ": " + err.Error())}
+			}
+			object[name] = raw
+		}
+		buf, err := json.Marshal(object)
+		if err != nil {
+			return nil, invalidParamsError{err}
+		}
+		request := reflect.New(typ).Interface()
+		if err := json.Unmarshal(buf, request); err != nil {
+			return nil, invalidParamsError{err}
+		}
+		return request, nil
+	}
+}
+
+// ArrayResultEncoder returns an EncodeResponseFunc that encodes a response
+// holding a slice or array as a JSON array, for methods returning several
+// results. A nil slice is encoded as an empty array rather than null, and a
+// response of any other kind yields an InternalError.
+func ArrayResultEncoder() EncodeResponseFunc {
+	return func(_ context.Context, response interface{}) (json.RawMessage, error) {
+		v := reflect.ValueOf(response)
+		switch v.Kind() {
+		case reflect.Slice:
+			if v.IsNil() {
+				return json.RawMessage("[]"), nil
+			}
+		case reflect.Array:
+		default:
+			return nil, internalError{fmt.Errorf("array result encoder: response is a %T, not a slice", response)}
+		}
+		return json.Marshal(response)
+	}
+}
+
+// ArrayResultDecoder returns a DecodeResponseFunc, for clients of methods
+// encoding their result with ArrayResultEncoder, that decodes the result
+// into a new value of the slice type pointed to by v, e.g. &[]int{}. The
+// slice itself is returned. A result that isn't an array yields an error.
+func ArrayResultDecoder(v interface{}) DecodeResponseFunc {
+	typ := reflect.TypeOf(v).Elem()
+	return func(_ context.Context, result json.RawMessage) (interface{}, error) {
+		if !bytes.HasPrefix(bytes.TrimSpace(result), []byte("[")) {
+			return nil, errors.New("jsonrpc: result is not an array")
+		}
+		response := reflect.New(typ)
+		if err := json.Unmarshal(result, response.Interface()); err != nil {
+			return nil, err
+		}
+		return response.Elem().Interface(), nil
+	}
+}
+
+// PreciseResultDecoder returns a DecodeResponseFunc that decodes the result
+// into an interface{} like encoding/json does, except that numbers are kept as
+// json.Number rather than converted to float64. Integers beyond 2^53, such as
+// int64 ids, thus survive decoding and re-encoding exactly; use Int64 or
+// Float64 of the json.Number to get at the value.
+func PreciseResultDecoder() DecodeResponseFunc {
+	return func(_ context.Context, result json.RawMessage) (interface{}, error) {
+		dec := json.NewDecoder(bytes.NewReader(result))
+		dec.UseNumber()
+		var response interface{}
+		if err := dec.Decode(&response); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+}
+
+// maxSafeInteger is the largest integer that float64 values, and thus the
+// numbers of JavaScript and many other JSON decoders, hold exactly: 2^53-1.
+const maxSafeInteger = 1<<53 - 1
+
+// PreciseResultEncoder returns an EncodeResponseFunc that encodes the response
+// as JSON, like encoding/json does, except that integers beyond 2^53-1 in
+// magnitude, such as int64 ids, are encoded as strings of their digits, e.g.
+// "9007199254740993", so that clients decoding numbers as doubles, such as
+// JavaScript ones, don't round them. Other numbers are left as they are. Go
+// clients can decode such fields with the ",string" option of their json tag.
+func PreciseResultEncoder() EncodeResponseFunc {
+	return func(_ context.Context, response interface{}) (json.RawMessage, error) {
+		raw, err := json.Marshal(response)
+		if err != nil {
+			return nil, err
+		}
+		return quoteLargeIntegers(raw), nil
+	}
+}
+
+// quoteLargeIntegers returns the JSON raw with the integers beyond
+// maxSafeInteger in magnitude turned into strings.
+func quoteLargeIntegers(raw []byte) json.RawMessage {
+	var (
+		out               = make([]byte, 0, len(raw))
+		inString, escaped bool
+	)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '-' || '0' <= c && c <= '9':
+			j := i + 1
+			for j < len(raw) && strings.IndexByte("0123456789.eE+-", raw[j]) >= 0 {
+				j++
+			}
+			if num := raw[i:j]; isLargeInteger(num) {
+				out = append(append(append(out, '"'), num...), '"')
+			} else {
+				out = append(out, num...)
+			}
+			i = j - 1
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// isLargeInteger reports whether the JSON number num is an integer beyond
+// maxSafeInteger in magnitude.
+func isLargeInteger(num []byte) bool {
+	if bytes.ContainsAny(num, ".eE") {
+		return false
+	}
+	n, err := strconv.ParseInt(string(num), 10, 64)
+	return err != nil || n > maxSafeInteger || n < -maxSafeInteger
+}
+
+// formValue converts the form value s to JSON for a field of type t.
+func formValue(t reflect.Type, s string) (json.RawMessage, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return json.Marshal(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(f)
+	}
+	if !json.Valid([]byte(s)) {
+		return nil, errors.New("invalid JSON value")
+	}
+	return json.RawMessage(s), nil
+}
@@ -0,0 +1,344 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestDecodeNamedRaw(t *testing.T) {
+	dec := jsonrpc.DecodeNamedRaw()
+	request, err := dec(context.Background(), json.RawMessage(`{"name":"x","count":3,"tags":["a","b"],"opts":{"deep":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	named := request.(map[string]json.RawMessage)
+	for k, want := range map[string]string{
+		"name":  `"x"`,
+		"count": `3`,
+		"tags":  `["a","b"]`,
+		"opts":  `{"deep":true}`,
+	} {
+		if have := string(named[k]); want != have {
+			t.Errorf("%s: want %s, have %s", k, want, have)
+		}
+	}
+
+	for _, params := range []string{`[1,2]`, `"x"`, ``} {
+		_, err := dec(context.Background(), json.RawMessage(params))
+		coder, ok := err.(jsonrpc.ErrorCoder)
+		if !ok {
+			t.Fatalf("%q: want ErrorCoder, have %v", params, err)
+		}
+		if want, have := jsonrpc.InvalidParamsError, coder.ErrorCode(); want != have {
+			t.Errorf("%q: want %d, have %d", params, want, have)
+		}
+	}
+}
+
+func TestRequireFields(t *testing.T) {
+	type params struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Limit int      `json:"limit"`
+	}
+	dec := jsonrpc.RequireFields(&params{}, "name", "tags")
+
+	request, err := dec(context.Background(), json.RawMessage(`{"name":"x","tags":["a"],"limit":5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := request.(*params)
+	if want, have := "x", p.Name; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := 5, p.Limit; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+
+	_, err = dec(context.Background(), json.RawMessage(`{"name":"","limit":5}`))
+	e, ok := err.(jsonrpc.Error)
+	if !ok {
+		t.Fatalf("want jsonrpc.Error, have %T", err)
+	}
+	if want, have := jsonrpc.InvalidParamsError, e.Code; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := []string{"name", "tags"}, e.Data; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestRequireFieldsJSONTags(t *testing.T) {
+	type params struct {
+		UserID int    `json:"user_id"`
+		Note   string `json:"note,omitempty"`
+	}
+	// Fields may be named by their Go or JSON name.
+	dec := jsonrpc.RequireFields(&params{}, "UserID", "note")
+
+	for _, body := range []string{`{"user_id":7,"note":"x"}`, `{"USER_ID":7,"Note":"x"}`} {
+		request, err := dec(context.Background(), json.RawMessage(body))
+		if err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if want, have := 7, request.(*params).UserID; want != have {
+			t.Errorf("%s: want %d, have %d", body, want, have)
+		}
+	}
+
+	_, err := dec(context.Background(), json.RawMessage(`{"UserID":7}`))
+	e, ok := err.(jsonrpc.Error)
+	if !ok {
+		t.Fatalf("want jsonrpc.Error, have %T", err)
+	}
+	if want, have := []string{"user_id", "note"}, e.Data; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestDecodeForm(t *testing.T) {
+	type search struct {
+		Query  string   `json:"q"`
+		Limit  int      `json:"limit"`
+		Exact  bool     `json:"exact"`
+		Min    *float64 `json:"min"`
+		Tags   []string `json:"tags"`
+		Secret string   `json:"-"`
+	}
+	dec := jsonrpc.DecodeForm(&search{})
+
+	request, err := dec(context.Background(), json.RawMessage(`{"q":"go kit","limit":"10","exact":"1","min":"0.5","tags":"[\"a\",\"b\"]","Secret":"x","other":"y"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	min := 0.5
+	want := &search{Query: "go kit", Limit: 10, Exact: true, Min: &min, Tags: []string{"a", "b"}}
+	if have := request.(*search); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	for _, params := range []string{`{"limit":"ten"}`, `{"exact":"maybe"}`, `{"tags":"[a"}`, `["q"]`} {
+		_, err := dec(context.Background(), json.RawMessage(params))
+		if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.InvalidParamsError {
+			t.Errorf("%s: want InvalidParamsError, have %v", params, err)
+		}
+	}
+}
+
+func TestParamsToStruct(t *testing.T) {
+	type transfer struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+	}
+	dec := jsonrpc.ParamsToStruct(&transfer{})
+
+	for _, tc := range []struct {
+		params string
+		want   *transfer
+	}{
+		{`{"from":"a","to":"b","amount":5}`, &transfer{From: "a", To: "b", Amount: 5}},
+		{``, &transfer{}},
+		{`null`, &transfer{}},
+	} {
+		request, err := dec(context.Background(), json.RawMessage(tc.params))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.params, err)
+		}
+		if have := request.(*transfer); !reflect.DeepEqual(tc.want, have) {
+			t.Errorf("%s: want %+v, have %+v", tc.params, tc.want, have)
+		}
+	}
+
+	for _, tc := range []struct {
+		params, message string
+	}{
+		{`["a","b",5]`, "named params"},
+		{`"a"`, "must be an object"},
+		{`{"amount":"five"}`, "amount"},
+	} {
+		_, err := dec(context.Background(), json.RawMessage(tc.params))
+		if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.InvalidParamsError {
+			t.Errorf("%s: want InvalidParamsError, have %v", tc.params, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: want %q in %q", tc.params, tc.message, err)
+		}
+	}
+}
+
+func TestPositionalParams(t *testing.T) {
+	type page struct {
+		Cursor string
+		Limit  int
+		Desc   bool
+	}
+	defaults := &page{Limit: 10}
+	dec := jsonrpc.PositionalParams(defaults)
+
+	for _, tc := range []struct {
+		params string
+		want   *page
+	}{
+		{`["abc"]`, &page{Cursor: "abc", Limit: 10}},
+		{`["abc", 10]`, &page{Cursor: "abc", Limit: 10}},
+		{`["abc", 25, true]`, &page{Cursor: "abc", Limit: 25, Desc: true}},
+		{`[]`, &page{Limit: 10}},
+		{`null`, &page{Limit: 10}},
+		{``, &page{Limit: 10}},
+	} {
+		request, err := dec(context.Background(), json.RawMessage(tc.params))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.params, err)
+		}
+		if have := request.(*page); !reflect.DeepEqual(tc.want, have) {
+			t.Errorf("%s: want %+v, have %+v", tc.params, tc.want, have)
+		}
+	}
+	if want, have := (&page{Limit: 10}), defaults; !reflect.DeepEqual(want, have) {
+		t.Errorf("defaults modified: want %+v, have %+v", want, have)
+	}
+
+	for _, tc := range []struct {
+		params, message string
+	}{
+		{`["abc", 25, true, 1]`, "at most 3 params"},
+		{`["abc", "ten"]`, "param 1"},
+		{`{"cursor":"abc"}`, "positional params"},
+		{`"abc"`, "must be an array"},
+	} {
+		_, err := dec(context.Background(), json.RawMessage(tc.params))
+		if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.InvalidParamsError {
+			t.Errorf("%s: want InvalidParamsError, have %v", tc.params, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: want %q in %q", tc.params, tc.message, err)
+		}
+	}
+}
+
+func TestArrayResult(t *testing.T) {
+	type reading struct {
+		Sensor string  `json:"sensor"`
+		Value  float64 `json:"value"`
+	}
+	readings := []reading{{"a", 1.5}, {"b", 2}, {"c", -3}}
+	responses := map[string]interface{}{"all": readings, "none": []reading(nil), "one": [1]reading{readings[0]}, "bad": readings[0]}
+	sm := jsonrpc.ServiceMap{}
+	for method, response := range responses {
+		response := response
+		sm[method] = jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return response, nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			jsonrpc.ArrayResultEncoder(),
+		)
+	}
+	server := httptest.NewServer(jsonrpc.NewServer(sm))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+
+	for method, want := range map[string][]reading{"all": readings, "none": {}, "one": readings[:1]} {
+		c := jsonrpc.NewClient(tgt, method, jsonrpc.ClientResponseDecoder(jsonrpc.ArrayResultDecoder(&[]reading{})))
+		have, err := c.Endpoint()(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: want %v, have %v", method, want, have)
+		}
+	}
+
+	_, err := jsonrpc.NewClient(tgt, "bad").Endpoint()(context.Background(), nil)
+	if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.InternalError {
+		t.Errorf("want InternalError, have %v", err)
+	}
+}
+
+func TestPreciseResultDecoder(t *testing.T) {
+	const big = int64(9007199254740993) // 2^53 + 1, not representable as a float64
+	sm := jsonrpc.ServiceMap{
+		"big": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				return map[string]interface{}{"id": big, "ids": []int64{big, -big}}, nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		),
+	}
+	server := httptest.NewServer(jsonrpc.NewServer(sm))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+
+	c := jsonrpc.NewClient(tgt, "big", jsonrpc.ClientResponseDecoder(jsonrpc.PreciseResultDecoder()))
+	response, err := c.Endpoint()(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := response.(map[string]interface{})
+	if !ok {
+		t.Fatalf("want a map, have %T", response)
+	}
+	n, ok := m["id"].(json.Number)
+	if !ok {
+		t.Fatalf("want a json.Number, have %T", m["id"])
+	}
+	if have, err := n.Int64(); err != nil || have != big {
+		t.Errorf("want %d, have %d (%v)", big, have, err)
+	}
+
+	// Re-encoding the decoded result must reproduce the digits exactly.
+	raw, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `{"id":9007199254740993,"ids":[9007199254740993,-9007199254740993]}`, string(raw); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestPreciseResultEncoder(t *testing.T) {
+	const big = int64(9007199254740993) // 2^53 + 1, not representable as a float64
+	type result struct {
+		ID    int64   `json:"id"`
+		Small int64   `json:"small"`
+		Ratio float64 `json:"ratio"`
+		Name  string  `json:"name"`
+		IDs   []int64 `json:"ids"`
+	}
+	sm := jsonrpc.ServiceMap{
+		"big": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				var r interface{} = result{ID: big, Small: 42, Ratio: 1e300, Name: `say "-9007199254740993"`, IDs: []int64{big, -big}}
+				return r, nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			jsonrpc.PreciseResultEncoder(),
+		),
+	}
+	resp := post(t, jsonrpc.NewServer(sm), `{"jsonrpc":"2.0","method":"big","id":1}`)
+	res := decodeResponse(t, resp)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	want := `{"id":"9007199254740993","small":42,"ratio":1e+300,"name":"say \"-9007199254740993\"","ids":["9007199254740993","-9007199254740993"]}`
+	if have := string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	// Go clients get the exact values back with the ",string" option.
+	var have struct {
+		ID int64 `json:"id,string"`
+	}
+	if err := json.Unmarshal(res.Result, &have); err != nil || have.ID != big {
+		t.Errorf("want %d, have %d (%v)", big, have.ID, err)
+	}
+}
@@ -0,0 +1,296 @@
+package jsonrpc
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error defines a JSON-RPC error that can be returned in a Response.
+// http://www.jsonrpc.org/specification#error_object
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error implements error.
+func (e Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return errorMessage[e.Code]
+}
+
+// ErrorCode returns the JSON-RPC error code associated with the error.
+func (e Error) ErrorCode() int {
+	return e.Code
+}
+
+// ErrorData implements ErrorDataer.
+func (e Error) ErrorData() interface{} {
+	return e.Data
+}
+
+// HTTPError is an error that sets the JSON-RPC error code along with the HTTP
+// status code and headers of the response, as it implements ErrorCoder,
+// StatusCoder and Headerer. It's intended to be returned from endpoints that
+// need to control the HTTP response, e.g. a 429 with a Retry-After header.
+type HTTPError struct {
+	Code    int
+	Message string
+	Status  int
+	Header  http.Header
+}
+
+// Error implements error.
+func (e HTTPError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return errorMessage[e.Code]
+}
+
+// ErrorCode implements ErrorCoder.
+func (e HTTPError) ErrorCode() int { return e.Code }
+
+// StatusCode implements StatusCoder.
+func (e HTTPError) StatusCode() int { return e.Status }
+
+// Headers implements Headerer.
+func (e HTTPError) Headers() http.Header { return e.Header }
+
+const (
+	// ParseError defines invalid JSON was received by the server.
+	// An error occurred on the server while parsing the JSON text.
+	ParseError int = -32700
+
+	// InvalidRequestError defines the JSON sent is not a valid Request object.
+	InvalidRequestError int = -32600
+
+	// MethodNotFoundError defines the method does not exist / is not available.
+	MethodNotFoundError int = -32601
+
+	// InvalidParamsError defines invalid method parameter(s).
+	InvalidParamsError int = -32602
+
+	// InternalError defines a server error.
+	InternalError int = -32603
+
+	// ServerBusyError defines the server is temporarily unable to handle the
+	// request, and the client should retry later. It's in the range reserved
+	// for implementation-defined server errors.
+	ServerBusyError int = -32000
+
+	// UnauthorizedError defines the request lacks valid credentials. It's in
+	// the range reserved for implementation-defined server errors.
+	UnauthorizedError int = -32001
+
+	// RequestTooLargeError defines the request body exceeds the size the
+	// server accepts. It's in the range reserved for implementation-defined
+	// server errors.
+	RequestTooLargeError int = -32002
+
+	// ValidationFailedError defines the params are well-formed, but fail the
+	// method's validation rules. It's in the range reserved for
+	// implementation-defined server errors.
+	ValidationFailedError int = -32003
+
+	// RateLimitedError defines the method was called more often than its rate
+	// limit allows, and the client should slow down. It's in the range
+	// reserved for implementation-defined server errors.
+	RateLimitedError int = -32004
+
+	// RequestCanceledError defines the request was canceled by the client
+	// before it was answered; see CancelNotifications. It's in the range
+	// reserved for implementation-defined server errors.
+	RequestCanceledError int = -32005
+)
+
+var errorMessage = map[int]string{
+	ParseError:            "Parse error",
+	InvalidRequestError:   "Invalid Request",
+	MethodNotFoundError:   "Method not found",
+	InvalidParamsError:    "Invalid params",
+	InternalError:         "Internal error",
+	ServerBusyError:       "Server busy",
+	UnauthorizedError:     "Unauthorized",
+	RequestTooLargeError:  "Request too large",
+	ValidationFailedError: "Validation failed",
+	RateLimitedError:      "Rate limit exceeded",
+	RequestCanceledError:  "Request canceled",
+}
+
+// ErrorMessage returns the standard message for the JSON-RPC error code. It
+// returns the empty string if the code is unknown.
+func ErrorMessage(code int) string {
+	return errorMessage[code]
+}
+
+// ErrorCoder is checked by DefaultErrorEncoder. If an error value implements
+// ErrorCoder, the result of ErrorCode will be used as the JSON-RPC error code
+// when encoding the error. By default, InternalError (-32603) is used.
+type ErrorCoder interface {
+	ErrorCode() int
+}
+
+// ErrorDataer is checked by DefaultErrorEncoder. If an error value implements
+// ErrorDataer, the result of ErrorData will be used as the data of the
+// JSON-RPC error object, e.g. to list validation failures in a structured
+// way. By default, the error object carries no data.
+type ErrorDataer interface {
+	ErrorData() interface{}
+}
+
+// ToJSONRPCError converts err into the error object sent to clients. An error
+// of type Error is used as is, including its Data. Otherwise, the object has
+// the message of err and, if err implements ErrorCoder, the provided code
+// instead of InternalError, and if it implements ErrorDataer, the provided
+// data. Errors built by this package for internal failures keep their cause
+// out of the message.
+func ToJSONRPCError(err error) *Error {
+	if e, ok := err.(Error); ok {
+		return &e
+	}
+	e := &Error{
+		Code:    InternalError,
+		Message: err.Error(),
+	}
+	if ec, ok := err.(ErrorCoder); ok {
+		e.Code = ec.ErrorCode()
+	}
+	if ed, ok := err.(ErrorDataer); ok {
+		e.Data = ed.ErrorData()
+	}
+	return e
+}
+
+// NewParseError returns the error for invalid JSON, with code ParseError.
+func NewParseError() error { return parseError{} }
+
+// NewInvalidRequestError returns the error for JSON that isn't a valid
+// request object, with code InvalidRequestError.
+func NewInvalidRequestError() error { return invalidRequestError{} }
+
+// NewMethodNotFoundError returns the error for a call of an unknown method,
+// with code MethodNotFoundError and a message naming the method.
+func NewMethodNotFoundError(method string) error { return methodNotFoundError{method} }
+
+// NewInvalidParamsError returns the error for params the method can't
+// accept, with code InvalidParamsError. The detail, e.g. a list of the
+// offending params, is sent as the error's data unless it's nil.
+func NewInvalidParamsError(detail interface{}) error {
+	return Error{Code: InvalidParamsError, Message: errorMessage[InvalidParamsError], Data: detail}
+}
+
+// NewInternalError returns the error for a failure of the server, with code
+// InternalError. The cause is kept out of the message sent to clients.
+func NewInternalError(cause error) error { return internalError{cause} }
+
+// DecodeError is returned by a DecodeRequestFunc for params that can't be
+// decoded into the request, e.g. because a member has the wrong type. It's
+// answered with InvalidParamsError.
+type DecodeError struct {
+	Err error
+}
+
+// Error implements error.
+func (e DecodeError) Error() string { return errorMessage[InvalidParamsError] + ": " + e.Err.Error() }
+
+// ErrorCode implements ErrorCoder.
+func (DecodeError) ErrorCode() int { return InvalidParamsError }
+
+// ValidationError is returned by a DecodeRequestFunc for params that decode
+// fine, but break a business rule, e.g. a negative amount. It's answered with
+// ValidationFailedError, or the code set with ServiceValidationErrorCode, and
+// carries Data, if non-nil, as the error's data.
+type ValidationError struct {
+	Err  error
+	Data interface{}
+}
+
+// Error implements error.
+func (e ValidationError) Error() string { return e.Err.Error() }
+
+// ErrorCode implements ErrorCoder.
+func (ValidationError) ErrorCode() int { return ValidationFailedError }
+
+// ErrorData implements ErrorDataer.
+func (e ValidationError) ErrorData() interface{} { return e.Data }
+
+type parseError struct{}
+
+func (parseError) Error() string  { return errorMessage[ParseError] }
+func (parseError) ErrorCode() int { return ParseError }
+
+type invalidRequestError struct{}
+
+func (invalidRequestError) Error() string  { return errorMessage[InvalidRequestError] }
+func (invalidRequestError) ErrorCode() int { return InvalidRequestError }
+
+type methodNotFoundError struct {
+	method string
+}
+
+func (e methodNotFoundError) Error() string {
+	return errorMessage[MethodNotFoundError] + ": " + e.method
+}
+
+func (methodNotFoundError) ErrorCode() int { return MethodNotFoundError }
+
+type invalidParamsError struct {
+	err error
+}
+
+func (e invalidParamsError) Error() string {
+	if e.err == nil {
+		return errorMessage[InvalidParamsError]
+	}
+	return errorMessage[InvalidParamsError] + ": " + e.err.Error()
+}
+
+func (invalidParamsError) ErrorCode() int { return InvalidParamsError }
+
+var errBodyTooLarge = errors.New("request body too large")
+
+var errDecodeTimeout = errors.New("timeout reading request body")
+
+var errStreamResponse = errors.New("stream endpoint must return a <-chan interface{}")
+
+// internalError hides its cause from clients; the cause is only meant for
+// server-side logging.
+type internalError struct {
+	err error
+}
+
+func (internalError) Error() string  { return errorMessage[InternalError] }
+func (internalError) ErrorCode() int { return InternalError }
+
+// timeoutError is returned by services whose endpoint exceeded its timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string { return "timeout after " + e.timeout.String() }
+func (timeoutError) ErrorCode() int  { return InternalError }
+
+type serverBusyError struct {
+	retryAfter time.Duration
+}
+
+func (serverBusyError) Error() string   { return errorMessage[ServerBusyError] }
+func (serverBusyError) ErrorCode() int  { return ServerBusyError }
+func (serverBusyError) StatusCode() int { return http.StatusServiceUnavailable }
+
+type rateLimitedError struct{}
+
+func (rateLimitedError) Error() string   { return errorMessage[RateLimitedError] }
+func (rateLimitedError) ErrorCode() int  { return RateLimitedError }
+func (rateLimitedError) StatusCode() int { return http.StatusTooManyRequests }
+
+func (e serverBusyError) Headers() http.Header {
+	seconds := int(math.Ceil(e.retryAfter.Seconds()))
+	return http.Header{"Retry-After": {strconv.Itoa(seconds)}}
+}
@@ -0,0 +1,171 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+type codedError struct{ code int }
+
+func (e codedError) Error() string  { return "coded" }
+func (e codedError) ErrorCode() int { return e.code }
+
+type validationError map[string]string
+
+func (e validationError) Error() string          { return "validation failed" }
+func (e validationError) ErrorCode() int         { return jsonrpc.InvalidParamsError }
+func (e validationError) ErrorData() interface{} { return map[string]string(e) }
+
+func TestToJSONRPCError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want jsonrpc.Error
+	}{
+		{
+			name: "plain",
+			err:  errors.New("boom"),
+			want: jsonrpc.Error{Code: jsonrpc.InternalError, Message: "boom"},
+		},
+		{
+			name: "ErrorCoder",
+			err:  codedError{-32001},
+			want: jsonrpc.Error{Code: -32001, Message: "coded"},
+		},
+		{
+			name: "Error",
+			err:  jsonrpc.Error{Code: -32002, Message: "with data", Data: []string{"a"}},
+			want: jsonrpc.Error{Code: -32002, Message: "with data", Data: []string{"a"}},
+		},
+		{
+			name: "ErrorDataer",
+			err:  validationError{"a": "required"},
+			want: jsonrpc.Error{Code: jsonrpc.InvalidParamsError, Message: "validation failed", Data: map[string]string{"a": "required"}},
+		},
+		{
+			name: "HTTPError",
+			err:  jsonrpc.HTTPError{Code: jsonrpc.InvalidParamsError, Status: http.StatusBadRequest},
+			want: jsonrpc.Error{Code: jsonrpc.InvalidParamsError, Message: "Invalid params"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if want, have := tc.want, *jsonrpc.ToJSONRPCError(tc.err); !reflect.DeepEqual(want, have) {
+				t.Errorf("want %+v, have %+v", want, have)
+			}
+		})
+	}
+}
+
+func TestDefaultErrorEncoderErrorData(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"validate": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				return nil, validationError{"name": "required", "age": "must be positive"}
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		),
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"validate"}`)))
+	var res struct {
+		Error struct {
+			Code int               `json:"code"`
+			Data map[string]string `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := jsonrpc.InvalidParamsError, res.Error.Code; want != have {
+		t.Errorf("want code %d, have %d", want, have)
+	}
+	if want, have := map[string]string{"name": "required", "age": "must be positive"}, res.Error.Data; !reflect.DeepEqual(want, have) {
+		t.Errorf("want data %v, have %v", want, have)
+	}
+}
+
+func TestServerErrorLocalizer(t *testing.T) {
+	german := map[string]string{"Method not found: sub": "Methode nicht gefunden: sub"}
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ErrorLocalizer(func(ctx context.Context, e *jsonrpc.Error) *jsonrpc.Error {
+			if !strings.HasPrefix(jsonrpc.RequestHeader(ctx).Get("Accept-Language"), "de") {
+				return nil
+			}
+			if m, ok := german[e.Message]; ok {
+				e.Message = m
+			}
+			e.Code = 0 // ignored
+			return e
+		}),
+	)
+	const body = `{"jsonrpc":"2.0","id":1,"method":"sub","params":[1,2]}`
+	for _, tc := range []struct {
+		lang, want string
+	}{
+		{"de-CH, en;q=0.5", "Methode nicht gefunden: sub"},
+		{"en", "Method not found: sub"},
+	} {
+		res := decodeResponse(t, postHeader(t, handler, body, http.Header{"Accept-Language": {tc.lang}}))
+		if res.Error == nil {
+			t.Fatalf("%s: want error, have none", tc.lang)
+		}
+		if want, have := tc.want, res.Error.Message; want != have {
+			t.Errorf("%s: want %q, have %q", tc.lang, want, have)
+		}
+		if want, have := jsonrpc.MethodNotFoundError, res.Error.Code; want != have {
+			t.Errorf("%s: want code %d, have %d", tc.lang, want, have)
+		}
+	}
+
+	var res []jsonrpc.Response
+	resp := postHeader(t, handler, "["+body+"]", http.Header{"Accept-Language": {"de"}})
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Error == nil || res[0].Error.Message != "Methode nicht gefunden: sub" {
+		t.Errorf("want the batch error localized, have %+v", res)
+	}
+}
+
+func TestErrorConstructors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+		msg  string
+		data interface{}
+	}{
+		{"parse", jsonrpc.NewParseError(), jsonrpc.ParseError, "Parse error", nil},
+		{"invalid request", jsonrpc.NewInvalidRequestError(), jsonrpc.InvalidRequestError, "Invalid Request", nil},
+		{"method not found", jsonrpc.NewMethodNotFoundError("sub"), jsonrpc.MethodNotFoundError, "Method not found: sub", nil},
+		{"invalid params", jsonrpc.NewInvalidParamsError([]string{"a"}), jsonrpc.InvalidParamsError, "Invalid params", []string{"a"}},
+		{"internal", jsonrpc.NewInternalError(errors.New("db down")), jsonrpc.InternalError, "Internal error", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if want, have := tc.code, tc.err.(jsonrpc.ErrorCoder).ErrorCode(); want != have {
+				t.Errorf("want code %d, have %d", want, have)
+			}
+			e := jsonrpc.ToJSONRPCError(tc.err)
+			if want, have := tc.msg, e.Message; want != have {
+				t.Errorf("want message %q, have %q", want, have)
+			}
+			if want, have := tc.data, e.Data; !reflect.DeepEqual(want, have) {
+				t.Errorf("want data %v, have %v", want, have)
+			}
+		})
+	}
+	if want, have := []string{"a"}, jsonrpc.NewInvalidParamsError([]string{"a"}).(jsonrpc.ErrorDataer).ErrorData(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want data %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,67 @@
+package jsonrpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+// DebugLogs makes the server collect the lines logged by each request,
+// including each request of a batch, through the logger returned by
+// CollectLogs. If the request fails, its lines are returned to the client in
+// the data of its error object, as {"data": <original data>, "logs":
+// [<lines>]}; for single HTTP requests, that's done by DefaultErrorEncoder. It
+// exposes server internals to clients, so it's meant for development only; by
+// default, nothing is collected.
+func DebugLogs() ServerOption {
+	return func(s *Server) { s.debugLogs = true }
+}
+
+// CollectLogs returns a logger whose lines, formatted as logfmt, are attached
+// to the response of the request in ctx if the server was configured with
+// DebugLogs. Otherwise, the logger discards everything.
+func CollectLogs(ctx context.Context) log.Logger {
+	c, ok := ctx.Value(contextKeyLogs).(*logCollector)
+	if !ok {
+		return log.NewNopLogger()
+	}
+	return log.NewLogfmtLogger(c)
+}
+
+// logCollector keeps every line written to it.
+type logCollector struct {
+	mtx   sync.Mutex
+	lines []string
+}
+
+func (c *logCollector) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.lines = append(c.lines, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// collectedLogs returns the lines collected so far for the request in ctx.
+func collectedLogs(ctx context.Context) []string {
+	c, ok := ctx.Value(contextKeyLogs).(*logCollector)
+	if !ok {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// withLogs returns e with the lines collected for the request in ctx, if any,
+// attached to its data.
+func withLogs(ctx context.Context, e *Error) *Error {
+	if logs := collectedLogs(ctx); len(logs) > 0 {
+		e.Data = struct {
+			Data interface{} `json:"data,omitempty"`
+			Logs []string    `json:"logs"`
+		}{e.Data, logs}
+	}
+	return e
+}
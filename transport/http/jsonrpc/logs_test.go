@@ -0,0 +1,81 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func loggingService() *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			logger := jsonrpc.CollectLogs(ctx)
+			logger.Log("step", "lookup", "user", 42)
+			logger.Log("step", "charge", "err", "declined")
+			return nil, errors.New("payment failed")
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+}
+
+func TestDebugLogs(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"pay": loggingService()}, jsonrpc.DebugLogs())
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"pay","id":1}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Fatalf("want %d, have %d", want, have)
+	}
+	data, ok := res.Error.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("want object data, have %#v", res.Error.Data)
+	}
+	want := []interface{}{"step=lookup user=42", "step=charge err=declined"}
+	if have := data["logs"]; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestDebugLogsDisabled(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"pay": loggingService()})
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"pay","id":1}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Fatalf("want %d, have %d", want, have)
+	}
+	if res.Error.Data != nil {
+		t.Errorf("want no data, have %v", res.Error.Data)
+	}
+}
+
+func TestDebugLogsBatch(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"pay": loggingService(), "add": addService()}, jsonrpc.DebugLogs())
+	resp := post(t, handler, `[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},{"jsonrpc":"2.0","method":"pay","id":2},{"jsonrpc":"2.0","method":"pay","id":3}]`)
+	defer resp.Body.Close()
+	var batch []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 3, len(batch); want != have {
+		t.Fatalf("want %d responses, have %d", want, have)
+	}
+	if batch[0].Error != nil {
+		t.Errorf("unexpected error: %v", batch[0].Error)
+	}
+	// Each request has its lines, and only its own.
+	want := []interface{}{"step=lookup user=42", "step=charge err=declined"}
+	for _, res := range batch[1:] {
+		if res.Error == nil {
+			t.Fatalf("id %s: want error, have result %s", res.ID, res.Result)
+		}
+		data, ok := res.Error.Data.(map[string]interface{})
+		if !ok {
+			t.Fatalf("id %s: want object data, have %#v", res.ID, res.Error.Data)
+		}
+		if have := data["logs"]; !reflect.DeepEqual(want, have) {
+			t.Errorf("id %s: want %v, have %v", res.ID, want, have)
+		}
+	}
+}
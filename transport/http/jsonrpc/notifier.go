@@ -0,0 +1,71 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// ErrNotifyUnsupported is returned by the Notifier of requests that arrived
+// on a transport that can't push messages to the client, such as HTTP.
+var ErrNotifyUnsupported = errors.New("jsonrpc: transport doesn't support server notifications")
+
+// Notifier sends notifications, i.e. requests without an id, to the client
+// of a connection, e.g. to push subscription updates.
+type Notifier interface {
+	Notify(method string, params interface{}) error
+}
+
+// NotifierFromContext returns the Notifier of the connection the request in
+// ctx arrived on, for use by endpoints. Requests served by NewWebsocketServer
+// and Peer have one; for all others, the Notifier returns
+// ErrNotifyUnsupported.
+func NotifierFromContext(ctx context.Context) Notifier {
+	if n, ok := ctx.Value(contextKeyNotifier).(Notifier); ok {
+		return n
+	}
+	return unsupportedNotifier{}
+}
+
+type unsupportedNotifier struct{}
+
+func (unsupportedNotifier) Notify(string, interface{}) error { return ErrNotifyUnsupported }
+
+// sendNotifier is a Notifier writing notifications with send.
+type sendNotifier func(v interface{}) error
+
+func (send sendNotifier) Notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return send(Request{JSONRPC: Version, Method: method, Params: raw})
+}
+
+// ProgressMethod is the method of the notifications sent by Progress.
+const ProgressMethod = "$/progress"
+
+// errProgressWithoutID is returned by Progress for requests without an id.
+var errProgressWithoutID = errors.New("jsonrpc: progress of a request without an id")
+
+// Progress sends partial, the progress made so far by the request in ctx,
+// e.g. a percentage or the results computed so far, to its client while it's
+// being served. It's sent as a notification of ProgressMethod whose params
+// hold the id of the request and partial, as in {"id":1,"value":50}, so the
+// client must be prepared to receive it. The result is still sent as usual
+// once the endpoint returns. As with NotifierFromContext, requests that
+// didn't arrive on a connection, e.g. over HTTP, get ErrNotifyUnsupported;
+// notifications have no id to report progress on, and get an error too.
+func Progress(ctx context.Context, partial interface{}) error {
+	id, _ := RequestIDFromContext(ctx)
+	if len(id) == 0 {
+		return errProgressWithoutID
+	}
+	return NotifierFromContext(ctx).Notify(ProgressMethod, progressParams{ID: id, Value: partial})
+}
+
+// progressParams are the params of the notifications sent by Progress.
+type progressParams struct {
+	ID    json.RawMessage `json:"id"`
+	Value interface{}     `json:"value"`
+}
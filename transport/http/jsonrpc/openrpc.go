@@ -0,0 +1,148 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// OpenRPCVersion is the version of the OpenRPC specification implemented by
+// the documents generated by OpenRPC.
+const OpenRPCVersion = "1.2.6"
+
+// OpenRPCInfo is the metadata of the API described by an OpenRPC document.
+type OpenRPCInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenRPCDiscoverMethod is the method by which OpenRPC clients discover the
+// document of a server, served by servers built with OpenRPCDiscovery.
+const OpenRPCDiscoverMethod = "rpc.discover"
+
+// OpenRPCDiscovery makes the server answer calls of OpenRPCDiscoverMethod
+// with the document returned by Server.OpenRPC, which describes the API with
+// info. The method is listed in the document itself. By default, the server
+// has no discovery method.
+func OpenRPCDiscovery(info OpenRPCInfo) ServerOption {
+	return func(s *Server) { s.openRPC = &info }
+}
+
+// OpenRPC returns an OpenRPC document describing the methods served by the
+// server, as returned by the OpenRPC function, with the info given to
+// OpenRPCDiscovery, if any.
+func (s Server) OpenRPC() (json.RawMessage, error) {
+	var info OpenRPCInfo
+	if s.openRPC != nil {
+		info = *s.openRPC
+	}
+	return OpenRPC(s.sm, info)
+}
+
+// openRPCHandler is the Handler of OpenRPCDiscoverMethod.
+type openRPCHandler struct {
+	sm   ServiceMap
+	info OpenRPCInfo
+}
+
+func (h openRPCHandler) ServeJSONRPC(context.Context, http.Header, json.RawMessage) (json.RawMessage, http.Header, error) {
+	doc, err := OpenRPC(h.sm, h.info)
+	if err != nil {
+		return nil, nil, internalError{err}
+	}
+	return doc, nil, nil
+}
+
+func (h openRPCHandler) describe() MethodDescriptor {
+	return MethodDescriptor{Description: "Returns the OpenRPC document of the server."}
+}
+
+type openRPCDocument struct {
+	OpenRPC string          `json:"openrpc"`
+	Info    OpenRPCInfo     `json:"info"`
+	Methods []openRPCMethod `json:"methods"`
+}
+
+type openRPCMethod struct {
+	Name           string                     `json:"name"`
+	Description    string                     `json:"description,omitempty"`
+	ParamStructure string                     `json:"paramStructure,omitempty"`
+	Params         []openRPCContentDescriptor `json:"params"`
+	Result         openRPCContentDescriptor   `json:"result"`
+	Errors         []Error                    `json:"errors,omitempty"`
+}
+
+type openRPCContentDescriptor struct {
+	Name     string          `json:"name"`
+	Required bool            `json:"required,omitempty"`
+	Schema   json.RawMessage `json:"schema"`
+}
+
+var emptySchema = json.RawMessage("{}")
+
+// OpenRPC returns an OpenRPC document describing the methods of sm, sorted by
+// name, with the descriptions, schemas and error codes set on their services.
+// A params schema of an object is listed as one param per property, passed
+// by name; any other params schema as a single param named "params". Methods
+// without schemas are listed with no params and a result matching any value.
+func OpenRPC(sm ServiceMap, info OpenRPCInfo) ([]byte, error) {
+	doc := openRPCDocument{
+		OpenRPC: OpenRPCVersion,
+		Info:    info,
+		Methods: []openRPCMethod{},
+	}
+	for _, d := range describe(sm) {
+		m := openRPCMethod{
+			Name:        d.Name,
+			Description: d.Description,
+			Params:      []openRPCContentDescriptor{},
+			Result:      openRPCContentDescriptor{Name: "result", Schema: emptySchema},
+		}
+		if d.ResultSchema != nil {
+			m.Result.Schema = d.ResultSchema
+		}
+		if d.ParamsSchema != nil {
+			var err error
+			if m.Params, m.ParamStructure, err = openRPCParams(d.ParamsSchema); err != nil {
+				return nil, err
+			}
+		}
+		for _, code := range d.ErrorCodes {
+			m.Errors = append(m.Errors, Error{Code: code, Message: ErrorMessage(code)})
+		}
+		doc.Methods = append(doc.Methods, m)
+	}
+	return json.Marshal(doc)
+}
+
+// openRPCParams splits a params schema into content descriptors, and returns
+// them along with the param structure they imply.
+func openRPCParams(schema json.RawMessage) ([]openRPCContentDescriptor, string, error) {
+	var s struct {
+		Type       string                     `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, "", err
+	}
+	if s.Type != "object" || s.Properties == nil {
+		return []openRPCContentDescriptor{{Name: "params", Required: true, Schema: schema}}, "", nil
+	}
+	required := map[string]bool{}
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]openRPCContentDescriptor, 0, len(names))
+	for _, name := range names {
+		params = append(params, openRPCContentDescriptor{Name: name, Required: required[name], Schema: s.Properties[name]})
+	}
+	return params, "by-name", nil
+}
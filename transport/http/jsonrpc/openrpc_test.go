@@ -0,0 +1,168 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestOpenRPC(t *testing.T) {
+	type transfer struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Memo   string `json:"memo,omitempty"`
+	}
+	type receipt struct {
+		ID   string   `json:"id"`
+		Tags []string `json:"tags"`
+	}
+	nop := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+			options...,
+		)
+	}
+	sm := jsonrpc.ServiceMap{
+		"transfer": nop(
+			jsonrpc.ServiceDescription("Moves funds."),
+			jsonrpc.ServiceSchema(transfer{}, receipt{}),
+			jsonrpc.ServiceErrorCodes(jsonrpc.InvalidParamsError),
+		),
+		"add":  addService(jsonrpc.ServiceSchema(json.RawMessage(`{"type":"array","items":{"type":"integer"}}`), 0)),
+		"ping": nop(),
+	}
+
+	raw, err := jsonrpc.OpenRPC(sm, jsonrpc.OpenRPCInfo{Title: "Bank", Version: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenRPC string `json:"openrpc"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Methods []struct {
+			Name           string `json:"name"`
+			Description    string `json:"description"`
+			ParamStructure string `json:"paramStructure"`
+			Params         []struct {
+				Name     string                 `json:"name"`
+				Required bool                   `json:"required"`
+				Schema   map[string]interface{} `json:"schema"`
+			} `json:"params"`
+			Result struct {
+				Name   string                 `json:"name"`
+				Schema map[string]interface{} `json:"schema"`
+			} `json:"result"`
+			Errors []jsonrpc.Error `json:"errors"`
+		} `json:"methods"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := jsonrpc.OpenRPCVersion, doc.OpenRPC; want != have {
+		t.Errorf("want openrpc %q, have %q", want, have)
+	}
+	if want, have := "Bank", doc.Info.Title; want != have {
+		t.Errorf("want title %q, have %q", want, have)
+	}
+	if want, have := 3, len(doc.Methods); want != have {
+		t.Fatalf("want %d methods, have %d", want, have)
+	}
+
+	add, ping, tr := doc.Methods[0], doc.Methods[1], doc.Methods[2]
+	if want, have := "add", add.Name; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := 1, len(add.Params); want != have || add.Params[0].Schema["type"] != "array" {
+		t.Errorf("want a single array param, have %+v", add.Params)
+	}
+	if want, have := "integer", add.Result.Schema["type"]; want != have {
+		t.Errorf("want result type %q, have %v", want, have)
+	}
+
+	if len(ping.Params) != 0 || len(ping.Result.Schema) != 0 {
+		t.Errorf("want no params and an empty result schema, have %+v", ping)
+	}
+
+	if want, have := "Moves funds.", tr.Description; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := "by-name", tr.ParamStructure; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	var names []string
+	for _, p := range tr.Params {
+		names = append(names, p.Name)
+		if want, have := p.Name != "memo", p.Required; want != have {
+			t.Errorf("%s: want required %v, have %v", p.Name, want, have)
+		}
+	}
+	if want, have := []string{"amount", "from", "memo", "to"}, names; !reflect.DeepEqual(want, have) {
+		t.Errorf("want params %v, have %v", want, have)
+	}
+	if want, have := "integer", tr.Params[0].Schema["type"]; want != have {
+		t.Errorf("want amount type %q, have %v", want, have)
+	}
+	tags, _ := tr.Result.Schema["properties"].(map[string]interface{})["tags"].(map[string]interface{})
+	if want, have := "array", tags["type"]; want != have {
+		t.Errorf("want tags type %q, have %v", want, have)
+	}
+	if want, have := []jsonrpc.Error{{Code: jsonrpc.InvalidParamsError, Message: "Invalid params"}}, tr.Errors; !reflect.DeepEqual(want, have) {
+		t.Errorf("want errors %+v, have %+v", want, have)
+	}
+}
+
+func TestServerOpenRPC(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"add":   addService(jsonrpc.ServiceSchema(json.RawMessage(`{"type":"array","items":{"type":"integer"}}`), 0)),
+			"count": countService(),
+		},
+		jsonrpc.OpenRPCDiscovery(jsonrpc.OpenRPCInfo{Title: "Calculator", Version: "2.1.0"}),
+	)
+	type document struct {
+		Info struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Methods []struct {
+			Name string `json:"name"`
+		} `json:"methods"`
+	}
+	check := func(raw json.RawMessage) {
+		t.Helper()
+		var doc document
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "Calculator", doc.Info.Title; want != have {
+			t.Errorf("want title %q, have %q", want, have)
+		}
+		var names []string
+		for _, m := range doc.Methods {
+			names = append(names, m.Name)
+		}
+		if want, have := []string{"add", "count", jsonrpc.OpenRPCDiscoverMethod}, names; !reflect.DeepEqual(want, have) {
+			t.Errorf("want methods %v, have %v", want, have)
+		}
+	}
+
+	raw, err := handler.OpenRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(raw)
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"rpc.discover","id":1}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	check(res.Result)
+}
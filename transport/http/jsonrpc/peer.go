@@ -0,0 +1,165 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPeerClosed is returned by the calls of a Peer that stopped serving
+// before they were answered.
+var ErrPeerClosed = errors.New("jsonrpc: peer closed")
+
+// Peer serves JSON-RPC requests and issues calls of its own over a single
+// connection, e.g. the stdin and stdout of a language server, which also
+// calls back into its client. Messages are JSON values, one after the other;
+// each sent by the peer is followed by a newline. Incoming requests, including
+// batches, are served by a Dispatcher as they arrive, and incoming responses
+// are matched to the calls of the peer by id. The peer is the Notifier of the
+// requests it serves.
+type Peer struct {
+	d   *Dispatcher
+	r   io.Reader
+	id  uint64
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mtx     sync.Mutex
+	pending map[string]chan Response
+	done    chan struct{}
+}
+
+// NewPeer constructs a Peer reading messages from r and writing them to w,
+// which serves requests with the handlers in sm. The options are those of
+// its Dispatcher. Serve must be called for requests to be served and calls
+// to be answered.
+func NewPeer(r io.Reader, w io.Writer, sm ServiceMap, options ...ServerOption) *Peer {
+	return &Peer{
+		d:       NewDispatcher(sm, options...),
+		r:       r,
+		enc:     json.NewEncoder(w),
+		pending: map[string]chan Response{},
+		done:    make(chan struct{}),
+	}
+}
+
+// Serve reads and handles messages until reading fails, e.g. because the
+// other side closed the connection, and returns the error, which is nil at
+// EOF. The requests being served are then canceled and waited for, and the
+// pending calls fail with ErrPeerClosed. Serve must be called only once.
+func (p *Peer) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, contextKeyNotifier, p))
+	c := newConn(p.d, p.send)
+	defer func() {
+		cancel()
+		c.wait()
+		close(p.done)
+	}()
+
+	dec := json.NewDecoder(p.r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			p.d.s.logger.Log("err", err)
+			return err
+		}
+		if p.isResponse(raw) {
+			continue
+		}
+		c.serve(ctx, raw)
+	}
+}
+
+// isResponse reports whether raw is a response, rather than a request, and
+// if so, passes it to the call waiting for it.
+func (p *Peer) isResponse(raw json.RawMessage) bool {
+	var msg struct {
+		Method *string `json:"method"`
+		Response
+	}
+	if bytes.HasPrefix(raw, []byte("[")) || json.Unmarshal(raw, &msg) != nil || msg.Method != nil {
+		return false
+	}
+	if msg.Result == nil && msg.Error == nil {
+		return false // an invalid request, left to the dispatcher
+	}
+	p.mtx.Lock()
+	c, ok := p.pending[string(msg.ID)]
+	delete(p.pending, string(msg.ID))
+	p.mtx.Unlock()
+	if !ok {
+		p.d.s.logger.Log("err", "response to unknown call", "id", string(msg.ID))
+		return true
+	}
+	c <- msg.Response
+	return true
+}
+
+// send writes the message v.
+func (p *Peer) send(v interface{}) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if err := p.enc.Encode(v); err != nil {
+		p.d.s.logger.Log("err", err)
+		return err
+	}
+	return nil
+}
+
+// Call calls method on the other side with the given params, which are
+// encoded as JSON, and decodes the result into result, which may be nil to
+// discard it. If the other side answers with an error object, it's returned
+// as an Error. Call may be called concurrently, including from the handlers
+// served by the peer.
+func (p *Peer) Call(ctx context.Context, method string, params, result interface{}) error {
+	select {
+	case <-p.done:
+		return ErrPeerClosed
+	default:
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(atomic.AddUint64(&p.id, 1), 10)
+	c := make(chan Response, 1)
+	p.mtx.Lock()
+	p.pending[id] = c
+	p.mtx.Unlock()
+	defer func() {
+		p.mtx.Lock()
+		delete(p.pending, id)
+		p.mtx.Unlock()
+	}()
+
+	if err := p.send(Request{JSONRPC: Version, Method: method, Params: raw, ID: json.RawMessage(id)}); err != nil {
+		return err
+	}
+	select {
+	case res := <-c:
+		if res.Error != nil {
+			return *res.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(res.Result, result)
+	case <-p.done:
+		return ErrPeerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify implements Notifier, sending a notification of method with the
+// given params, which are encoded as JSON, to the other side.
+func (p *Peer) Notify(method string, params interface{}) error {
+	return sendNotifier(p.send).Notify(method, params)
+}
@@ -0,0 +1,119 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestPeer(t *testing.T) {
+	var (
+		clientR, serverW = io.Pipe()
+		serverR, clientW = io.Pipe()
+		server           *jsonrpc.Peer
+	)
+	// The server's "hover" method calls back into the client before it
+	// answers, as language servers do.
+	hover := jsonrpc.NewService(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			var config string
+			if err := server.Call(ctx, "workspace/configuration", request, &config); err != nil {
+				return nil, err
+			}
+			return "hover with " + config, nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var section string
+			err := json.Unmarshal(params, &section)
+			return section, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	server = jsonrpc.NewPeer(serverR, serverW, jsonrpc.ServiceMap{"add": addService(), "hover": hover})
+	client := jsonrpc.NewPeer(clientR, clientW, jsonrpc.ServiceMap{
+		"workspace/configuration": jsonrpc.NewService(
+			func(_ context.Context, request interface{}) (interface{}, error) {
+				return request.(string) + "=tabs", nil
+			},
+			func(_ context.Context, params json.RawMessage) (interface{}, error) {
+				var section string
+				err := json.Unmarshal(params, &section)
+				return section, err
+			},
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		),
+	})
+
+	errc := make(chan error, 2)
+	go func() { errc <- server.Serve(context.Background()) }()
+	go func() { errc <- client.Serve(context.Background()) }()
+
+	var sum int
+	if err := client.Call(context.Background(), "add", []int{2, 3}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 5, sum; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+
+	var text string
+	if err := client.Call(context.Background(), "hover", "editor", &text); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hover with editor=tabs", text; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	var config string
+	if err := server.Call(context.Background(), "workspace/configuration", "indent", &config); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "indent=tabs", config; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	err := client.Call(context.Background(), "missing", nil, nil)
+	if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.MethodNotFoundError {
+		t.Errorf("want MethodNotFoundError, have %v", err)
+	}
+
+	clientW.Close()
+	serverW.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("want Serve to return nil at EOF, have %v", err)
+		}
+	}
+	if want, have := jsonrpc.ErrPeerClosed, client.Call(context.Background(), "add", []int{1, 1}, nil); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestPeerCancelNotifications(t *testing.T) {
+	var (
+		clientR, serverW = io.Pipe()
+		serverR, clientW = io.Pipe()
+		entered          = make(chan struct{}, 1)
+		server           = jsonrpc.NewPeer(serverR, serverW, jsonrpc.ServiceMap{"block": blockService(entered)},
+			jsonrpc.CancelNotifications(jsonrpc.CancelRequestMethod))
+		client = jsonrpc.NewPeer(clientR, clientW, jsonrpc.ServiceMap{})
+	)
+	go server.Serve(context.Background())
+	go client.Serve(context.Background())
+	defer clientW.Close()
+	defer serverW.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- client.Call(context.Background(), "block", nil, nil) }()
+	<-entered
+	if err := client.Notify(jsonrpc.CancelRequestMethod, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	err := <-errc
+	if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.RequestCanceledError {
+		t.Errorf("want RequestCanceledError, have %v", err)
+	}
+}
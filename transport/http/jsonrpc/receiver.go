@@ -0,0 +1,110 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewServiceFromReceiver builds a ServiceMap from the exported methods of
+// rcvr of the form
+//
+//	func(context.Context, ArgT) (ReplyT, error)
+//
+// in the manner of net/rpc. Each method is served by a Service whose params
+// are decoded as JSON into an ArgT, and whose result is the ReplyT encoded as
+// JSON. Methods are named like their Go counterparts with a lowercase first
+// letter, e.g. Add is served as "add". Absent or null params yield the zero
+// ArgT or, if ArgT is a pointer, a pointer to a zero value, as in net/rpc.
+//
+// Methods of other forms are skipped. If any is, the ServiceMap of the other
+// methods is returned along with an error giving the reason for each skipped
+// method.
+func NewServiceFromReceiver(rcvr interface{}, options ...ServiceOption) (ServiceMap, error) {
+	var (
+		v       = reflect.ValueOf(rcvr)
+		t       = v.Type()
+		sm      = ServiceMap{}
+		skipped []string
+	)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if m.PkgPath != "" {
+			continue // unexported
+		}
+		if err := checkReceiverMethod(m.Type); err != nil {
+			skipped = append(skipped, m.Name+": "+err.Error())
+			continue
+		}
+		sm[lowerFirst(m.Name)] = receiverService(v.Method(i), options)
+	}
+	if len(skipped) > 0 {
+		return sm, fmt.Errorf("jsonrpc: skipped methods of %s: %s", t, strings.Join(skipped, "; "))
+	}
+	return sm, nil
+}
+
+// checkReceiverMethod reports why a method of type t, including its receiver,
+// can't be served, if it can't.
+func checkReceiverMethod(t reflect.Type) error {
+	switch {
+	case t.NumIn() != 3:
+		return errors.New("want 2 arguments")
+	case t.In(1) != typeOfContext:
+		return errors.New("first argument must be a context.Context")
+	case t.NumOut() != 2:
+		return errors.New("want 2 results")
+	case t.Out(1) != typeOfError:
+		return errors.New("second result must be an error")
+	}
+	return nil
+}
+
+// receiverService returns a Service calling method, a method value.
+func receiverService(method reflect.Value, options []ServiceOption) *Service {
+	argType := method.Type().In(1)
+	argIsPtr := argType.Kind() == reflect.Ptr
+	if argIsPtr {
+		argType = argType.Elem()
+	}
+	return NewService(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			out := method.Call([]reflect.Value{reflect.ValueOf(ctx), request.(reflect.Value)})
+			if err, _ := out[1].Interface().(error); err != nil {
+				return nil, err
+			}
+			return out[0].Interface(), nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			arg := reflect.New(argType)
+			if len(params) > 0 {
+				if err := json.Unmarshal(params, arg.Interface()); err != nil {
+					return nil, invalidParamsError{err}
+				}
+			}
+			if argIsPtr {
+				return arg, nil
+			}
+			return arg.Elem(), nil
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+		options...,
+	)
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
@@ -0,0 +1,80 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+type arith struct{}
+
+type pair struct {
+	A, B int
+}
+
+func (arith) Add(_ context.Context, p pair) (int, error) { return p.A + p.B, nil }
+
+func (arith) Div(_ context.Context, p *pair) (int, error) {
+	if p.B == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return p.A / p.B, nil
+}
+
+func (arith) Negate(n int) int { return -n }
+
+func (arith) Zero(context.Context, struct{}) (int, error) { return 0, nil }
+
+func TestNewServiceFromReceiver(t *testing.T) {
+	sm, err := jsonrpc.NewServiceFromReceiver(arith{})
+	if err == nil || !strings.Contains(err.Error(), "Negate") {
+		t.Errorf("want Negate reported as skipped, have %v", err)
+	}
+	var methods []string
+	for method := range sm {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	if want, have := "add div zero", strings.Join(methods, " "); want != have {
+		t.Fatalf("want methods %s, have %s", want, have)
+	}
+
+	handler := jsonrpc.NewServer(sm)
+	for _, tc := range []struct {
+		body, want string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"add","params":{"A":1,"B":2}}`, `3`},
+		{`{"jsonrpc":"2.0","id":1,"method":"div","params":{"A":6,"B":3}}`, `2`},
+		{`{"jsonrpc":"2.0","id":1,"method":"zero"}`, `0`},
+	} {
+		res := decodeResponse(t, post(t, handler, tc.body))
+		if res.Error != nil {
+			t.Fatalf("%s: %v", tc.body, res.Error)
+		}
+		if want, have := tc.want, string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", tc.body, want, have)
+		}
+	}
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"div","params":{"A":6,"B":0}}`))
+	if res.Error == nil || res.Error.Message != "division by zero" {
+		t.Errorf("want division by zero, have %v", res.Error)
+	}
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"div"}`,
+		`{"jsonrpc":"2.0","id":1,"method":"div","params":null}`,
+	} {
+		res := decodeResponse(t, post(t, handler, body))
+		if res.Error == nil || res.Error.Message != "division by zero" {
+			t.Errorf("%s: want division by zero, have %v", body, res.Error)
+		}
+	}
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if want, have := jsonrpc.InvalidParamsError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,35 @@
+package jsonrpc
+
+import "sync"
+
+var (
+	registryMtx sync.Mutex
+	registry    = ServiceMap{}
+)
+
+// Register adds h to the default service map under method, so that packages
+// can contribute methods from their init functions. Like http.Handle, it
+// panics if the method is already registered, or if h is nil.
+func Register(method string, h Handler) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	if h == nil {
+		panic("jsonrpc: nil handler for method " + method)
+	}
+	if _, dup := registry[method]; dup {
+		panic("jsonrpc: method " + method + " registered twice")
+	}
+	registry[method] = h
+}
+
+// DefaultServiceMap returns a copy of the ServiceMap of all methods added with
+// Register, e.g. for passing to NewServer once every package is initialized.
+func DefaultServiceMap() ServiceMap {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	sm := make(ServiceMap, len(registry))
+	for method, h := range registry {
+		sm[method] = h
+	}
+	return sm
+}
@@ -0,0 +1,54 @@
+package jsonrpc_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// registrations numbers the methods registered by the tests, which need
+// names of their own on every run, e.g. with -count=2, as the registry lives
+// as long as the process.
+var registrations int
+
+func uniqueMethod(name string) string {
+	registrations++
+	return "registry." + name + "." + strconv.Itoa(registrations)
+}
+
+func TestRegister(t *testing.T) {
+	// Two packages contributing their methods.
+	add, count := uniqueMethod("add"), uniqueMethod("count")
+	jsonrpc.Register(add, addService())
+	jsonrpc.Register(count, countService())
+
+	sm := jsonrpc.DefaultServiceMap()
+	for _, method := range []string{add, count} {
+		if _, ok := sm[method]; !ok {
+			t.Errorf("%s not in default service map", method)
+		}
+	}
+
+	delete(sm, add)
+	if _, ok := jsonrpc.DefaultServiceMap()[add]; !ok {
+		t.Error("modifying the returned map changed the default service map")
+	}
+
+	handler := jsonrpc.NewServer(jsonrpc.DefaultServiceMap())
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"`+add+`","params":[1,2],"id":1}`))
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	dup := uniqueMethod("dup")
+	jsonrpc.Register(dup, addService())
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic for duplicate registration")
+		}
+	}()
+	jsonrpc.Register(dup, addService())
+}
@@ -0,0 +1,35 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestFunc may take information from the headers of an HTTP request and
+// put it into a request context. In Servers and Services, RequestFuncs are
+// executed prior to invoking the endpoint. In Clients, RequestFuncs are
+// executed prior to sending the request, and may set its headers instead.
+type RequestFunc func(context.Context, http.Header) context.Context
+
+// ErroringRequestFunc is a RequestFunc that may reject the request by
+// returning a non-nil error. Processing of the request stops at the first
+// error, which is then returned in place of a result.
+type ErroringRequestFunc func(context.Context, http.Header) (context.Context, error)
+
+// ServiceResponseFunc may take information from a request context and use it
+// to manipulate the headers of the HTTP response. ServiceResponseFuncs are
+// only executed in services, after invoking the endpoint but prior to
+// encoding the result.
+type ServiceResponseFunc func(context.Context, http.Header) context.Context
+
+// ServiceResponseFuncWithResult is a ServiceResponseFunc that's also passed
+// the response returned by the endpoint, e.g. to stamp a correlation header
+// derived from it and the request id. It's executed after the
+// ServiceResponseFuncs.
+type ServiceResponseFuncWithResult func(ctx context.Context, h http.Header, response interface{}) context.Context
+
+// ClientResponseFunc may take information from the headers of an HTTP
+// response and make the response available for consumption. ClientResponseFuncs
+// are only executed in clients, after a request has been made, but prior to
+// the result being decoded.
+type ClientResponseFunc func(context.Context, http.Header) context.Context
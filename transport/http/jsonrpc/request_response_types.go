@@ -0,0 +1,114 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Version is the JSON-RPC protocol version implemented by this package.
+const Version = "2.0"
+
+// Version1 is the original JSON-RPC protocol version, which servers may be
+// configured to accept alongside Version with AcceptVersions. Its requests
+// carry no jsonrpc member.
+// https://www.jsonrpc.org/specification_v1
+const Version1 = "1.0"
+
+// ContentType is the content type used for JSON-RPC responses.
+const ContentType = "application/json; charset=utf-8"
+
+// Request defines a JSON-RPC request as described by the spec.
+// http://www.jsonrpc.org/specification#request_object
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// notification reports whether r is a notification, i.e. a request the
+// client expects no response to. In 2.0, that's a request without an id; in
+// 1.0, one with a null id.
+func (r Request) notification() bool {
+	if r.JSONRPC == Version {
+		return len(r.ID) == 0
+	}
+	return len(r.ID) == 0 || string(r.ID) == "null"
+}
+
+// Response defines a JSON-RPC response as described by the spec.
+// http://www.jsonrpc.org/specification#response_object
+// The ID is that of the request, and null if the request's id couldn't be
+// determined.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// response1 is a JSON-RPC 1.0 response. Unlike in 2.0, the result, error and
+// id members are always present, and the one not in use is null.
+type response1 struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// newResponse returns the response to the request in ctx, in the protocol
+// version of that request.
+func newResponse(ctx context.Context, result json.RawMessage, err *Error) interface{} {
+	id, _ := ctx.Value(contextKeyRequestID).(json.RawMessage)
+	if v, _ := ctx.Value(contextKeyVersion).(string); v == Version1 {
+		return response1{Result: result, Error: err, ID: id}
+	}
+	return Response{JSONRPC: Version, ID: id, Result: result, Error: err}
+}
+
+// IDKind restricts the JSON type of request ids accepted by a server.
+type IDKind int
+
+const (
+	// AnyID accepts ids of any JSON type.
+	AnyID IDKind = iota
+
+	// IntegerID accepts only integer ids.
+	IntegerID
+
+	// StringID accepts only string ids.
+	StringID
+)
+
+// accepts reports whether id, the raw id of a request, is of kind k. Requests
+// without an id are notifications, which are accepted regardless of kind.
+func (k IDKind) accepts(id json.RawMessage) bool {
+	if len(id) == 0 || k == AnyID {
+		return true
+	}
+	switch k {
+	case IntegerID:
+		var n int64
+		return (id[0] == '-' || '0' <= id[0] && id[0] <= '9') && json.Unmarshal(id, &n) == nil
+	case StringID:
+		return id[0] == '"'
+	}
+	return false
+}
+
+// StatusPolicy controls the HTTP status of error responses written by
+// DefaultErrorEncoder, for errors implementing StatusCoder.
+type StatusPolicy int
+
+const (
+	// FromErrorButKeepBody uses the error's status code and writes the
+	// JSON-RPC error object as the body.
+	FromErrorButKeepBody StatusPolicy = iota
+
+	// FromError uses the error's status code. If it isn't 200, no body is
+	// written, as for a plain HTTP error.
+	FromError
+
+	// Always200 ignores the error's status code and always answers with 200,
+	// for clients behind gateways that strip the bodies of other statuses.
+	Always200
+)
@@ -0,0 +1,39 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// NewParamRouter returns a Handler that dispatches each request to one of
+// routes, chosen by the string value of the discriminator member of the
+// params, which must be an object. The params are passed to the chosen
+// Handler unchanged. Requests whose discriminator is missing or has no route
+// yield an InvalidParamsError.
+func NewParamRouter(discriminator string, routes map[string]Handler) Handler {
+	return paramRouter{discriminator, routes}
+}
+
+type paramRouter struct {
+	discriminator string
+	routes        map[string]Handler
+}
+
+// ServeJSONRPC implements Handler.
+func (r paramRouter) ServeJSONRPC(ctx context.Context, h http.Header, params json.RawMessage) (json.RawMessage, http.Header, error) {
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(params, &named); err != nil {
+		return nil, nil, invalidParamsError{err}
+	}
+	var key string
+	if err := json.Unmarshal(named[r.discriminator], &key); err != nil {
+		return nil, nil, invalidParamsError{fmt.Errorf("%s must be a string", r.discriminator)}
+	}
+	route, ok := r.routes[key]
+	if !ok {
+		return nil, nil, invalidParamsError{fmt.Errorf("unknown %s %q", r.discriminator, key)}
+	}
+	return route.ServeJSONRPC(ctx, h, params)
+}
@@ -0,0 +1,51 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func constService(v string) *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) { return v, nil },
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+}
+
+func TestParamRouter(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"search": jsonrpc.NewParamRouter("kind", map[string]jsonrpc.Handler{
+			"user":  constService("users"),
+			"order": constService("orders"),
+		}),
+	})
+	for _, tc := range []struct {
+		params string
+		want   string
+		code   int
+	}{
+		{`{"kind":"user","q":"a"}`, `"users"`, 0},
+		{`{"kind":"order","q":"a"}`, `"orders"`, 0},
+		{`{"kind":"invoice","q":"a"}`, ``, jsonrpc.InvalidParamsError},
+		{`{"q":"a"}`, ``, jsonrpc.InvalidParamsError},
+		{`["user"]`, ``, jsonrpc.InvalidParamsError},
+	} {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"search","params":`+tc.params+`}`))
+		if tc.code != 0 {
+			if want, have := tc.code, errorCode(t, res); want != have {
+				t.Errorf("%s: want %d, have %d", tc.params, want, have)
+			}
+			continue
+		}
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.params, res.Error)
+		}
+		if want, have := tc.want, string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", tc.params, want, have)
+		}
+	}
+}
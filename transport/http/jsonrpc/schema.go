@@ -0,0 +1,216 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaOf reflects a JSON Schema from the Go type of v, following the rules
+// of encoding/json: structs are objects whose properties are named by their
+// json tags, and whose fields without omitempty are required; maps are objects
+// too, slices and arrays are arrays, except for byte slices, which are
+// strings. Types implementing json.Marshaler, and interfaces, are described by
+// the empty schema, which any value matches.
+func SchemaOf(v interface{}) map[string]interface{} {
+	if v == nil {
+		return map[string]interface{}{}
+	}
+	return schemaOfType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+var typeOfMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// schemaOfType returns the schema of t. seen holds the struct types being
+// described, so that recursive types end in the empty schema.
+func schemaOfType(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(typeOfMarshaler) || reflect.PtrTo(t).Implements(typeOfMarshaler) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOfType(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOfType(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := map[string]interface{}{}
+		required := []string{}
+		addProperties(t, seen, properties, &required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// addProperties adds the properties of the fields of the struct type t to
+// properties, flattening embedded structs as encoding/json does.
+func addProperties(t reflect.Type, seen map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addProperties(ft, seen, properties, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOfType(f.Type, seen)
+		if !strings.Contains(opts, ",omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// validateSchema checks the JSON document doc against the JSON Schema schema.
+// Only the type, enum, properties, required, additionalProperties and items
+// keywords are supported; others are ignored.
+func validateSchema(schema, doc json.RawMessage) error {
+	var s, v interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return err
+	}
+	return validateValue(s, v, "$")
+}
+
+func validateValue(schema, v interface{}, path string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil // true, or any other schema we don't understand
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, v) {
+		return fmt.Errorf("%s: want type %v, have %s", path, t, typeOf(v))
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		var found bool
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value not in enum", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		required, _ := s["required"].([]interface{})
+		for _, r := range required {
+			if name, _ := r.(string); name != "" {
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		properties, _ := s["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps, ok := properties[k]
+			if !ok {
+				if s["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+				ps = s["additionalProperties"]
+			}
+			if err := validateValue(ps, v[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if err := validateValue(s["items"], e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesType reports whether v matches the type keyword t, which is either
+// a type name or a list of them.
+func matchesType(t, v interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		have := typeOf(v)
+		return have == t || t == "number" && have == "integer"
+	case []interface{}:
+		for _, tt := range t {
+			if matchesType(tt, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,1195 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// Server wraps a ServiceMap and implements http.Handler.
+type Server struct {
+	sm             ServiceMap
+	before         []RequestFunc
+	erroringBefore []ErroringRequestFunc
+	errorEncoder   httptransport.ErrorEncoder
+	finalizer      []httptransport.ServerFinalizerFunc
+	maintenance    *atomic.Bool
+	maintErr       Error
+	ready          func() bool
+	warmupCode     int
+	indent         string
+	idKind         IDKind
+	bodyLogger     log.Logger
+	bodyLogMax     int
+	maxResponse    int64
+	decodeTimeout  time.Duration
+	defaultDec     DecodeRequestFunc
+	serverTiming   bool
+	streamsParams  bool
+	schemas        map[string]json.RawMessage
+	versions       []string
+	batchWorkers   int
+	batchErrors    func(context.Context, []error)
+	coalesce       func(Request) string
+	localize       func(context.Context, *Error) *Error
+	maxBody        int64
+	contentTypes   []string
+	verify         func(context.Context, []byte, http.Header) error
+	rewrite        func(context.Context, Request) (Request, error)
+	compress       bool
+	compressMin    int
+	introspection  string
+	openRPC        *OpenRPCInfo
+	statusPolicy   StatusPolicy
+	timeout        time.Duration
+	getMethods     map[string]bool
+	unsupported    func(http.ResponseWriter, *http.Request)
+	audit          func(AuditEntry)
+	auditExclude   map[string]bool
+	logger         log.Logger
+	cancelMethod   string
+	connLimit      rate.Limit
+	connBurst      int
+	maxPending     int
+	debugLogs      bool
+}
+
+// NewServer constructs a new server, which implements http.Handler and
+// dispatches JSON-RPC requests to the handlers in the provided ServiceMap.
+func NewServer(
+	sm ServiceMap,
+	options ...ServerOption,
+) *Server {
+	s := &Server{
+		sm:           sm,
+		errorEncoder: DefaultErrorEncoder,
+		unsupported:  methodNotAllowed,
+		versions:     []string{Version},
+		logger:       log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.introspection != "" || s.openRPC != nil {
+		s.sm = make(ServiceMap, len(sm)+2)
+		for method, h := range sm {
+			s.sm[method] = h
+		}
+	}
+	if s.introspection != "" {
+		s.sm[s.introspection] = describeHandler{s.sm}
+	}
+	if s.openRPC != nil {
+		s.sm[OpenRPCDiscoverMethod] = openRPCHandler{s.sm, *s.openRPC}
+	}
+	for _, h := range sm {
+		if _, ok := h.(ParamsStreamHandler); ok {
+			s.streamsParams = true
+		}
+	}
+	return s
+}
+
+// ServerOption sets an optional parameter for servers.
+type ServerOption func(*Server)
+
+// ServerBefore functions are executed on the HTTP request headers before the
+// request is dispatched.
+func ServerBefore(before ...RequestFunc) ServerOption {
+	return func(s *Server) { s.before = append(s.before, before...) }
+}
+
+// ServerErroringBefore functions are executed on the HTTP request headers
+// after any ServerBefore functions, but before the request is dispatched. If
+// one of them returns an error, the request is not dispatched and the error
+// is passed to the error encoder instead. This is the place for checks, such
+// as authentication, that apply to every method.
+func ServerErroringBefore(before ...ErroringRequestFunc) ServerOption {
+	return func(s *Server) { s.erroringBefore = append(s.erroringBefore, before...) }
+}
+
+// ServerErrorEncoder is used to encode errors to the http.ResponseWriter
+// whenever they're encountered in the processing of a request. Clients can
+// use this to provide custom error formatting. By default, errors will be
+// written with the DefaultErrorEncoder.
+func ServerErrorEncoder(ee httptransport.ErrorEncoder) ServerOption {
+	return func(s *Server) { s.errorEncoder = ee }
+}
+
+// ServerErrorLogger is used to log the errors of failed requests, including
+// those that never reach a handler, such as malformed bodies, unknown methods
+// and responses that can't be encoded, before they're passed to the error
+// encoder. By default, no errors are logged. This is intended as a diagnostic
+// measure.
+func ServerErrorLogger(logger log.Logger) ServerOption {
+	return func(s *Server) { s.logger = logger }
+}
+
+// Warmup makes the server reject every request with an error of rejectCode,
+// typically ServerBusyError, and an HTTP status of 503, without dispatching
+// it, until ready returns true, e.g. once caches are filled after startup.
+// ready is called on every request, so it should be cheap. By default, the
+// server is ready right away.
+func Warmup(ready func() bool, rejectCode int) ServerOption {
+	return func(s *Server) {
+		s.ready = ready
+		s.warmupCode = rejectCode
+	}
+}
+
+// ServerFinalizer functions are executed at the end of every HTTP request, in
+// order, with the status code of the response. The response headers,
+// including those set by services, and the response size are provided in the
+// context under the httptransport.ContextKeyResponse keys. By default, no
+// finalizer is registered.
+func ServerFinalizer(f ...httptransport.ServerFinalizerFunc) ServerOption {
+	return func(s *Server) { s.finalizer = append(s.finalizer, f...) }
+}
+
+// MaintenanceMode makes the server respond to every request with an error
+// of the given code and message, without dispatching it, for as long as
+// enabled is set. The flag is read on every request, so it may be toggled
+// while the server is running.
+func MaintenanceMode(enabled *atomic.Bool, code int, message string) ServerOption {
+	return func(s *Server) {
+		s.maintenance = enabled
+		s.maintErr = Error{Code: code, Message: message}
+	}
+}
+
+// PrettyResponses makes the server indent encoded responses, including those
+// written by DefaultErrorEncoder, with the given indent string. It's intended
+// for development; by default, responses are compact.
+func PrettyResponses(indent string) ServerOption {
+	return func(s *Server) { s.indent = indent }
+}
+
+// RequireIDType makes the server reject requests whose id is not of the given
+// kind with InvalidRequestError. Requests without an id are unaffected. By
+// default, ids of any kind are accepted.
+func RequireIDType(kind IDKind) ServerOption {
+	return func(s *Server) { s.idKind = kind }
+}
+
+// LogBodyOnDecodeError logs the first maxBytes of the request body to logger
+// whenever the body can't be decoded as a JSON-RPC request. By default, the
+// body isn't captured.
+func LogBodyOnDecodeError(logger log.Logger, maxBytes int) ServerOption {
+	return func(s *Server) {
+		s.bodyLogger = logger
+		s.bodyLogMax = maxBytes
+	}
+}
+
+// MaxResponseSize limits the size of encoded results to n bytes. A result
+// exceeding it is replaced by an InternalError, and the offending method is
+// logged to the server's error logger. By default, results aren't limited.
+func MaxResponseSize(n int64) ServerOption {
+	return func(s *Server) { s.maxResponse = n }
+}
+
+// DecodeTimeout bounds the time spent reading and decoding the request body
+// to d, protecting the server from clients sending their body very slowly.
+// It sets a read deadline on the connection, so it has no effect on
+// ResponseWriters that don't support one, such as httptest.ResponseRecorder.
+// When exceeded, the request is answered with InvalidRequestError and an HTTP
+// status of 408, and the connection is closed. By default, decoding isn't
+// bounded.
+func DecodeTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.decodeTimeout = d }
+}
+
+// DefaultParamsDecoder sets the decoder used by services constructed without
+// one, i.e. with a nil DecodeRequestFunc. A service's own decoder always takes
+// precedence. By default, a service without a decoder fails every request
+// with InternalError.
+func DefaultParamsDecoder(dec DecodeRequestFunc) ServerOption {
+	return func(s *Server) { s.defaultDec = dec }
+}
+
+// IncludeServerTiming adds a non-standard serverTimeMs member to successful
+// responses, holding the time in milliseconds the server spent on the
+// request. It's intended for debugging and load testing; streamed results
+// and 1.0 responses don't carry it. By default, it's not included.
+func IncludeServerTiming() ServerOption {
+	return func(s *Server) { s.serverTiming = true }
+}
+
+// ValidateResponses checks the result of each method with an entry in
+// schemaPerMethod against that JSON Schema before it's sent. A result that
+// doesn't match is logged to the server's error logger and replaced by an
+// InternalError. Validation is meant to catch encoder bugs during development
+// and is too costly for production. Streamed results aren't validated.
+func ValidateResponses(schemaPerMethod map[string]json.RawMessage) ServerOption {
+	return func(s *Server) { s.schemas = schemaPerMethod }
+}
+
+// AcceptVersions sets the JSON-RPC protocol versions accepted by the server,
+// out of Version and Version1. A request without a jsonrpc member is taken to
+// be a Version1 request. Each request is answered in its own version; 1.0
+// responses always carry result, error and id, and errors are encoded as
+// they would be in 2.0. By default, only Version is accepted.
+func AcceptVersions(versions ...string) ServerOption {
+	return func(s *Server) { s.versions = versions }
+}
+
+// BatchConcurrency lets the server work on up to n requests of a batch at
+// once. The results are still returned in the order of the requests. By
+// default, the requests of a batch are served one after the other.
+func BatchConcurrency(n int) ServerOption {
+	return func(s *Server) { s.batchWorkers = n }
+}
+
+// StatusCodePolicy sets how DefaultErrorEncoder combines the HTTP status code
+// of an error with the JSON-RPC error object. By default, the policy is
+// FromErrorButKeepBody.
+func StatusCodePolicy(p StatusPolicy) ServerOption {
+	return func(s *Server) { s.statusPolicy = p }
+}
+
+// DefaultTimeout bounds each endpoint invocation of the server's Services to
+// d, unless the service sets its own with ServiceTimeout, so that no endpoint
+// runs unbounded by accident. By default, invocations aren't bounded.
+func DefaultTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.timeout = d }
+}
+
+// AllowGET lets clients call the given methods with GET requests, passing
+// the method name, id and params as query parameters, e.g.
+// ?method=add&id=1&a=1&b=2. The params are passed to the service as an
+// object of strings, using the first value of each query parameter; see
+// DecodeForm. Ids that are integers are passed as such, others as strings.
+// GET requests for other methods are answered with an HTTP status of 405.
+// By default, only POST requests are accepted.
+func AllowGET(methods ...string) ServerOption {
+	return func(s *Server) {
+		s.getMethods = map[string]bool{}
+		for _, method := range methods {
+			s.getMethods[method] = true
+		}
+	}
+}
+
+// BatchErrorAggregator is called once per batch with the errors of all its
+// failed requests, in order, if any failed, e.g. to log them in a single
+// line. Each failed request still gets its own error object. By default,
+// batch errors aren't aggregated.
+func BatchErrorAggregator(f func(ctx context.Context, errs []error)) ServerOption {
+	return func(s *Server) { s.batchErrors = f }
+}
+
+// UnsupportedMethodHandler sets the function answering requests with an HTTP
+// method the server doesn't accept, e.g. to map a PUT forwarded by a gateway
+// to a JSON-RPC error. By default, such requests get a plain-text 405.
+func UnsupportedMethodHandler(f func(w http.ResponseWriter, r *http.Request)) ServerOption {
+	return func(s *Server) { s.unsupported = f }
+}
+
+// ServerMaxBodySize limits the size of request bodies to n bytes. Requests
+// with larger bodies are answered with RequestTooLargeError and an HTTP
+// status of 413, and only the first n bytes are read; uncompressed bodies
+// whose Content-Length exceeds n aren't read at all, so that the requests of
+// a batch aren't answered before the body turns out too large. By default,
+// bodies aren't limited.
+func ServerMaxBodySize(n int64) ServerOption {
+	return func(s *Server) { s.maxBody = n }
+}
+
+// ServerRequireContentType makes the server reject POST requests whose
+// Content-Type, stripped of parameters such as charset, isn't one of
+// contentTypes with InvalidRequestError and an HTTP status of 415. By
+// default, any Content-Type is accepted.
+func ServerRequireContentType(contentTypes ...string) ServerOption {
+	return func(s *Server) { s.contentTypes = contentTypes }
+}
+
+// VerifyBody makes the server read the whole request body and pass it to
+// verify, along with the request headers, before decoding it, e.g. to check
+// an HMAC signature over the exact bytes sent. If verify returns an error, the
+// request isn't decoded and the error is passed to the error encoder, so
+// verify decides the error seen by the client, e.g. by returning an Error or
+// HTTPError. Bodies are buffered in full, so the option is best combined with
+// ServerMaxBodySize. By default, bodies are decoded as they're read.
+func VerifyBody(verify func(ctx context.Context, raw []byte, h http.Header) error) ServerOption {
+	return func(s *Server) { s.verify = verify }
+}
+
+// RequestRewriter makes the server pass each decoded request, including each
+// request of a batch, to rewrite before looking up its method, and serve the
+// request returned instead, e.g. to adapt a proprietary envelope to JSON-RPC.
+// If rewrite returns an error, it's passed to the error encoder and the
+// request isn't served. Params aren't streamed to a ParamsStreamHandler when
+// a rewriter is set, since the rewriter needs the whole request. By default,
+// requests are served as decoded.
+func RequestRewriter(rewrite func(ctx context.Context, req Request) (Request, error)) ServerOption {
+	return func(s *Server) { s.rewrite = rewrite }
+}
+
+// ErrorLocalizer sets a function that DefaultErrorEncoder, and the encoding
+// of batch results, pass each error object to before it's sent, e.g. to
+// translate its message according to the Accept-Language header returned by
+// RequestHeader. The code of the object can't be changed, as clients rely on
+// it; a nil object leaves the error as is. By default, errors aren't
+// localized.
+func ErrorLocalizer(f func(ctx context.Context, e *Error) *Error) ServerOption {
+	return func(s *Server) { s.localize = f }
+}
+
+// ServeHTTP implements http.Handler. Request bodies with a Content-Encoding
+// of gzip are decompressed before they're decoded, and yield ParseError if
+// they can't be; ServerMaxBodySize applies to the decompressed body.
+// Connections of HTTP/1.0 clients that don't ask for them to be kept alive
+// are closed after the response, which says so with a Connection: close
+// header.
+func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 && !hasToken(r.Header["Connection"], "keep-alive") {
+		w.Header().Set("Connection", "close")
+	}
+
+	if r.Method != http.MethodPost && (r.Method != http.MethodGet || s.getMethods == nil) {
+		s.unsupported(w, r)
+		return
+	}
+
+	begin := time.Now()
+	ctx := s.withValues(context.WithValue(r.Context(), contextKeyRequestHeader, r.Header))
+	if s.indent != "" {
+		ctx = context.WithValue(ctx, contextKeyIndent, s.indent)
+	}
+	if s.statusPolicy != FromErrorButKeepBody {
+		ctx = context.WithValue(ctx, contextKeyStatusPolicy, s.statusPolicy)
+	}
+	if s.compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r.Header) {
+			ctx = context.WithValue(ctx, contextKeyCompressMin, s.compressMin)
+		}
+	}
+
+	if len(s.finalizer) > 0 {
+		iw := &interceptingWriter{w, http.StatusOK, 0}
+		defer func() {
+			ctx = context.WithValue(ctx, contextKeyBegin, begin)
+			ctx = context.WithValue(ctx, httptransport.ContextKeyResponseHeaders, iw.Header())
+			ctx = context.WithValue(ctx, httptransport.ContextKeyResponseSize, iw.written)
+			for _, f := range s.finalizer {
+				f(ctx, iw.code, r)
+			}
+		}()
+		w = iw
+	}
+
+	ctx, audit := s.startAudit(ctx)
+	defer audit()
+
+	ctx, err := s.runBefore(ctx, r.Header)
+	if err != nil {
+		s.fail(ctx, w, err)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		req, err := s.requestFromQuery(r.URL.Query())
+		if err != nil {
+			s.fail(ctx, w, err)
+			return
+		}
+		s.dispatch(ctx, w, r, req, begin)
+		return
+	}
+
+	if s.contentTypes != nil {
+		if err := s.checkContentType(r.Header.Get("Content-Type")); err != nil {
+			s.fail(ctx, w, err)
+			return
+		}
+	}
+
+	var rc io.ReadCloser = r.Body
+	if s.decodeTimeout > 0 {
+		ctl := http.NewResponseController(w)
+		if err := ctl.SetReadDeadline(time.Now().Add(s.decodeTimeout)); err == nil {
+			rc = deadlineReader{rc, ctl}
+		}
+	}
+	gzipped := hasToken(r.Header["Content-Encoding"], "gzip")
+	if s.maxBody > 0 && !gzipped && r.ContentLength > s.maxBody {
+		s.decodeError(ctx, w, errBodyTooLarge, nil)
+		return
+	}
+	if gzipped {
+		var err error
+		if rc, err = gunzipBody(rc); err != nil {
+			s.decodeError(ctx, w, err, nil)
+			return
+		}
+	}
+	var (
+		body io.Reader = rc
+		head *prefixWriter
+	)
+	if s.maxBody > 0 {
+		body = &maxBytesReader{r: http.MaxBytesReader(w, rc, s.maxBody), n: s.maxBody}
+	}
+	if s.bodyLogger != nil {
+		head = &prefixWriter{n: s.bodyLogMax}
+		body = io.TeeReader(body, head)
+	}
+	if s.verify != nil {
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			s.decodeError(ctx, w, err, head)
+			return
+		}
+		if err := s.verify(ctx, raw, r.Header); err != nil {
+			s.fail(ctx, w, err)
+			return
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	br := bufio.NewReader(body)
+	if isBatch(br) {
+		s.serveBatch(ctx, w, r, json.NewDecoder(br), head)
+		return
+	}
+
+	if s.streamsParams {
+		s.serveParamsStream(ctx, w, r, json.NewDecoder(br), head, begin)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(br).Decode(&req); err != nil {
+		s.decodeError(ctx, w, err, head)
+		return
+	}
+
+	s.dispatch(ctx, w, r, req, begin)
+}
+
+// runBefore runs the ServerBefore and ServerErroringBefore functions on the
+// request headers h, stopping at the first error.
+func (s Server) runBefore(ctx context.Context, h http.Header) (context.Context, error) {
+	for _, f := range s.before {
+		ctx = f(ctx, h)
+	}
+	for _, f := range s.erroringBefore {
+		var err error
+		if ctx, err = f(ctx, h); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+// withValues returns a copy of ctx carrying the server's settings that
+// handlers and the encoding of errors depend on, whatever the transport.
+func (s Server) withValues(ctx context.Context) context.Context {
+	if s.defaultDec != nil {
+		ctx = context.WithValue(ctx, contextKeyDefaultDecoder, s.defaultDec)
+	}
+	if s.timeout > 0 {
+		ctx = context.WithValue(ctx, contextKeyDefaultTimeout, s.timeout)
+	}
+	if s.localize != nil {
+		ctx = context.WithValue(ctx, contextKeyLocalizer, s.localize)
+	}
+	return ctx
+}
+
+// dispatch serves a decoded request with serveRequest, streaming the result
+// of a StreamHandler to w as it's produced, and writes the reply.
+func (s Server) dispatch(ctx context.Context, w http.ResponseWriter, r *http.Request, req Request, begin time.Time) {
+	var streamed bool
+	rep := s.serveRequest(ctx, r.Header, req, func(ctx context.Context, req Request, h Handler) (json.RawMessage, http.Header, error) {
+		sh, ok := h.(StreamHandler)
+		if !ok || req.notification() {
+			return h.ServeJSONRPC(ctx, r.Header, req.Params)
+		}
+		streamed = true
+		return nil, nil, s.serveStream(ctx, w, r, req, sh)
+	})
+	if !streamed {
+		s.writeReply(w, rep, begin)
+	}
+}
+
+// reply is the outcome of serving a single request with serveRequest.
+type reply struct {
+	ctx context.Context // carries the request's id and version
+	res interface{}     // the response to send, nil for a notification
+	rh  http.Header
+	err error
+}
+
+// invokeFunc calls the handler h of req.
+type invokeFunc func(ctx context.Context, req Request, h Handler) (json.RawMessage, http.Header, error)
+
+// serveRequest is the dispatch core shared by single HTTP requests, batches
+// and Dispatchers. It applies the RequestRewriter, validates req and looks up
+// its handler, calls it with the request headers h, checks the result, audits
+// and logs the outcome, and builds the response, carrying the lines collected
+// under DebugLogs if it failed. Per the spec, notifications get no response,
+// not even an error, unless they're invalid requests; their headers are still
+// returned. If invoke is non-nil, it calls the handler instead of
+// ServeJSONRPC, e.g. to stream the result; a nil result it returns isn't
+// checked.
+func (s Server) serveRequest(ctx context.Context, h http.Header, req Request, invoke invokeFunc) (rep reply) {
+	defer func() {
+		if rep.err != nil {
+			s.logger.Log("method", req.Method, "err", rep.err)
+		}
+		s.auditRequest(rep.ctx, req, rep.err)
+	}()
+	var (
+		handler Handler
+		result  json.RawMessage
+		err     error
+	)
+	if s.debugLogs {
+		ctx = context.WithValue(ctx, contextKeyLogs, &logCollector{})
+	}
+	if ctx, req, err = s.rewriteRequest(ctx, req); err == nil {
+		ctx, handler, err = s.prepare(ctx, req)
+	}
+	rep.ctx = ctx
+	if err == nil {
+		if invoke == nil {
+			result, rep.rh, err = handler.ServeJSONRPC(ctx, h, req.Params)
+		} else {
+			result, rep.rh, err = invoke(ctx, req, handler)
+		}
+	}
+	if err == nil && result != nil {
+		err = s.checkResult(req, result)
+	}
+	rep.err = err
+
+	if _, invalid := err.(invalidRequestError); req.notification() && !invalid {
+		return rep
+	}
+	if err != nil {
+		rep.res = newResponse(ctx, nil, withLogs(ctx, localizedError(ctx, err)))
+		return rep
+	}
+	rep.res = newResponse(ctx, result, nil)
+	return rep
+}
+
+// fail logs err, which failed the request as a whole, and passes it to the
+// error encoder.
+func (s Server) fail(ctx context.Context, w http.ResponseWriter, err error) {
+	s.logger.Log("err", err)
+	auditFailure(ctx, err)
+	s.errorEncoder(ctx, err, w)
+}
+
+// checkContentType enforces ServerRequireContentType.
+func (s Server) checkContentType(contentType string) error {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, ct := range s.contentTypes {
+		if strings.EqualFold(ct, mediaType) {
+			return nil
+		}
+	}
+	if mediaType == "" {
+		mediaType = "missing"
+	}
+	return HTTPError{
+		Code:    InvalidRequestError,
+		Message: "Content-Type " + mediaType + " not supported, want " + strings.Join(s.contentTypes, " or "),
+		Status:  http.StatusUnsupportedMediaType,
+	}
+}
+
+// hasToken reports whether the comma-separated header values contain token,
+// ignoring case.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func methodNotAllowed(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	io.WriteString(w, "405 must POST\n")
+}
+
+// requestFromQuery builds the request of a GET request served under AllowGET
+// from its query parameters.
+func (s Server) requestFromQuery(q url.Values) (Request, error) {
+	req := Request{JSONRPC: Version, Method: q.Get("method")}
+	if !s.getMethods[req.Method] {
+		return req, HTTPError{
+			Code:    InvalidRequestError,
+			Message: "method must be called with POST",
+			Status:  http.StatusMethodNotAllowed,
+			Header:  http.Header{"Allow": {http.MethodPost}},
+		}
+	}
+	if _, ok := q["id"]; ok {
+		id := q.Get("id")
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			req.ID = json.RawMessage(strconv.FormatInt(n, 10))
+		} else {
+			req.ID, _ = json.Marshal(id)
+		}
+	}
+	params := map[string]string{}
+	for k := range q {
+		if k != "method" && k != "id" {
+			params[k] = q.Get(k)
+		}
+	}
+	req.Params, _ = json.Marshal(params)
+	return req, nil
+}
+
+// decodeError answers a request whose body couldn't be decoded. A body that
+// isn't JSON at all, including an empty, truncated or malformed gzipped one,
+// yields ParseError; JSON that isn't a request object yields
+// InvalidRequestError.
+func (s Server) decodeError(ctx context.Context, w http.ResponseWriter, err error, head *prefixWriter) {
+	s.logger.Log("err", err)
+	if head != nil {
+		s.bodyLogger.Log("err", err, "body", string(head.buf))
+	}
+	var e error
+	switch {
+	case err == errBodyTooLarge:
+		e = HTTPError{
+			Code:   RequestTooLargeError,
+			Status: http.StatusRequestEntityTooLarge,
+		}
+	case err == errDecodeTimeout:
+		e = HTTPError{
+			Code:    InvalidRequestError,
+			Message: "timeout reading request",
+			Status:  http.StatusRequestTimeout,
+		}
+	case isParseError(err):
+		e = parseError{}
+	default:
+		e = invalidRequestError{}
+	}
+	auditFailure(ctx, e)
+	s.errorEncoder(ctx, e, w)
+}
+
+// isParseError reports whether err, returned while decoding a request, means
+// the request isn't valid JSON.
+func isParseError(err error) bool {
+	_, ok := err.(*json.SyntaxError)
+	return ok || err == io.EOF || err == io.ErrUnexpectedEOF || err == errMalformedGzip
+}
+
+// prepare validates a decoded request and looks up its handler.
+func (s Server) prepare(ctx context.Context, req Request) (context.Context, Handler, error) {
+	version := req.JSONRPC
+	if version == "" {
+		version = Version1
+	}
+	if version == Version1 && string(req.ID) == "null" {
+		req.ID = nil // a 1.0 notification
+	}
+
+	if !s.idKind.accepts(req.ID) {
+		return ctx, nil, invalidRequestError{}
+	}
+	ctx = context.WithValue(ctx, contextKeyRequestID, req.ID)
+
+	if !s.accepts(version) {
+		return ctx, nil, invalidRequestError{}
+	}
+	ctx = context.WithValue(ctx, contextKeyVersion, version)
+
+	if req.Method == "" {
+		return ctx, nil, invalidRequestError{}
+	}
+
+	if s.maintenance != nil && s.maintenance.Load() {
+		return ctx, nil, s.maintErr
+	}
+	if s.ready != nil && !s.ready() {
+		return ctx, nil, HTTPError{Code: s.warmupCode, Message: "Server warming up", Status: http.StatusServiceUnavailable}
+	}
+
+	h, ok := s.sm[req.Method]
+	if !ok {
+		return ctx, nil, methodNotFoundError{req.Method}
+	}
+	ctx = context.WithValue(ctx, contextKeyMethod, req.Method)
+	if mh, ok := h.(methodInfoer); ok && mh.methodInfo() != nil {
+		info := *mh.methodInfo()
+		info.Name = req.Method
+		ctx = context.WithValue(ctx, contextKeyMethodInfo, info)
+	}
+	return ctx, h, nil
+}
+
+// rewriteRequest applies the RequestRewriter, if any, to req. On error, the
+// original request is returned, and its id stored in the context, so the error
+// is still answered with it.
+func (s Server) rewriteRequest(ctx context.Context, req Request) (context.Context, Request, error) {
+	if s.rewrite == nil {
+		return ctx, req, nil
+	}
+	rewritten, err := s.rewrite(ctx, req)
+	if err != nil {
+		return context.WithValue(ctx, contextKeyRequestID, req.ID), req, err
+	}
+	return ctx, rewritten, nil
+}
+
+func (s Server) accepts(version string) bool {
+	for _, v := range s.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// writeReply writes rep, the reply to a single request, to w. Notifications
+// are answered with an HTTP status of 204, along with the handler's headers,
+// and errors are passed to the error encoder.
+func (s Server) writeReply(w http.ResponseWriter, rep reply, begin time.Time) {
+	if rep.res == nil {
+		for k, v := range rep.rh {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if rep.err != nil {
+		s.errorEncoder(rep.ctx, rep.err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	for k, v := range rep.rh {
+		w.Header()[k] = v
+	}
+
+	res := rep.res
+	if r, ok := res.(Response); ok && s.serverTiming {
+		res = struct {
+			Response
+			ServerTimeMs float64 `json:"serverTimeMs"`
+		}{r, time.Since(begin).Seconds() * 1e3}
+	}
+	s.writeResponse(rep.ctx, w, res)
+}
+
+// writeResponse writes the successful response res with an HTTP status of
+// 200, logging any failure. If res can't be encoded, e.g. because a result
+// isn't valid JSON, nothing has been written yet, so the request is answered
+// with an InternalError instead.
+func (s Server) writeResponse(ctx context.Context, w http.ResponseWriter, res interface{}) {
+	err := encodeResponse(ctx, w, http.StatusOK, res)
+	if err == nil {
+		return
+	}
+	s.logger.Log("err", "can't write response: "+err.Error())
+	if e, ok := err.(encodeError); ok {
+		s.errorEncoder(ctx, internalError{e.err}, w)
+	}
+}
+
+// checkResult enforces MaxResponseSize and ValidateResponses on the result of
+// req, logging any violation.
+func (s Server) checkResult(req Request, result json.RawMessage) error {
+	if s.maxResponse > 0 && int64(len(result)) > s.maxResponse {
+		s.logger.Log("method", req.Method, "err", "response too large", "size", len(result))
+		return Error{Code: InternalError, Message: "response too large"}
+	}
+	if schema, ok := s.schemas[req.Method]; ok {
+		if err := validateSchema(schema, result); err != nil {
+			s.logger.Log("method", req.Method, "err", "invalid response: "+err.Error())
+			return internalError{err}
+		}
+	}
+	return nil
+}
+
+// serveParamsStream decodes the request from dec member by member, so that a
+// ParamsStreamHandler can decode the params straight from the body. That's
+// only possible if the jsonrpc, method and id members precede params, as the
+// request must be validated, and known not to be a notification, before its
+// handler is called; otherwise the params are buffered and the request is
+// served as usual.
+func (s Server) serveParamsStream(ctx context.Context, w http.ResponseWriter, r *http.Request, dec *json.Decoder, head *prefixWriter, begin time.Time) {
+	var (
+		served bool
+		rep    reply
+	)
+	req, err := decodeRequest(dec, func(partial Request) bool {
+		if _, ok := s.sm[partial.Method].(ParamsStreamHandler); !ok || s.rewrite != nil {
+			return false
+		}
+		var consumed bool
+		rep = s.serveRequest(ctx, r.Header, partial, func(ctx context.Context, _ Request, h Handler) (json.RawMessage, http.Header, error) {
+			consumed = true
+			return h.(ParamsStreamHandler).ServeJSONRPCParams(ctx, r.Header, dec)
+		})
+		served = true
+		return consumed // the params of a rejected request are skipped
+	})
+	if served {
+		if err != nil && rep.err == nil {
+			s.fail(rep.ctx, w, invalidRequestError{}) // the rest of the request is malformed
+			return
+		}
+		s.writeReply(w, rep, begin)
+		return
+	}
+	if err != nil {
+		s.decodeError(ctx, w, err, head)
+		return
+	}
+
+	s.dispatch(ctx, w, r, req, begin)
+}
+
+// decodeRequest decodes a request object from dec one member at a time,
+// matching member names case-insensitively like encoding/json does. Once the
+// params member is reached, and if the jsonrpc, method and id members have
+// been read by then, onParams is called with the request decoded so far. If
+// onParams reports that it consumed the params value from dec, Params is left
+// empty; otherwise it's decoded as usual.
+func decodeRequest(dec *json.Decoder, onParams func(Request) bool) (Request, error) {
+	var req Request
+	if err := expectDelim(dec, '{'); err != nil {
+		return req, err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return req, err
+		}
+		key, _ := t.(string)
+		switch {
+		case strings.EqualFold(key, "jsonrpc"):
+			err = dec.Decode(&req.JSONRPC)
+		case strings.EqualFold(key, "method"):
+			err = dec.Decode(&req.Method)
+		case strings.EqualFold(key, "id"):
+			err = dec.Decode(&req.ID)
+		case strings.EqualFold(key, "params"):
+			if req.JSONRPC == "" || req.Method == "" || req.ID == nil || !onParams(req) {
+				err = dec.Decode(&req.Params)
+			}
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return req, err
+		}
+	}
+	return req, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v, found %v", delim, t)
+	}
+	return nil
+}
+
+// serveStream writes the result of sh to w as it's produced, flushing after
+// each element. Responses too large to be buffered by net/http are sent
+// chunked. A failure after the first element leaves the response
+// unterminated, so that clients can't mistake it for a complete result, and
+// is passed to the error encoder with a context for which ResponseCommitted
+// is true. The error, if any, is returned.
+func (s Server) serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, req Request, sh StreamHandler) error {
+	var (
+		flusher, _ = w.(http.Flusher)
+		started    bool
+		id, _      = json.Marshal(ctx.Value(contextKeyRequestID))
+		prefix     = `{"jsonrpc":"` + Version + `","id":` + string(id) + `,"result":[`
+		suffix     = "]}\n"
+	)
+	if v, _ := ctx.Value(contextKeyVersion).(string); v == Version1 {
+		prefix, suffix = `{"result":[`, `],"error":null,"id":`+string(id)+"}\n"
+	}
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Trailer", ErrorTrailer)
+		w.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(w, prefix)
+		return err
+	}
+	err := sh.ServeJSONRPCStream(ctx, r.Header, req.Params, func(element json.RawMessage) error {
+		sep := ","
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+			sep = ""
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(element); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			s.errorEncoder(ctx, err, w)
+			return err
+		}
+		s.errorEncoder(context.WithValue(ctx, contextKeyCommitted, true), err, w)
+		return err
+	}
+	if !started {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	io.WriteString(w, suffix)
+	return nil
+}
+
+// DefaultErrorEncoder writes the error to the ResponseWriter as a JSON-RPC
+// error response, in the protocol version of the request. The error object is
+// built by ToJSONRPCError. If the error implements Headerer, the provided
+// headers will be applied to the response. If the error implements
+// StatusCoder, the provided StatusCode will be used instead of 200, subject to
+// the server's StatusCodePolicy. Lines collected for the request under
+// DebugLogs are added to the error's data, and the server's ErrorLocalizer,
+// if any, is applied. If the response is already committed, the error object
+// is sent in the ErrorTrailer trailer instead.
+func DefaultErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	if ResponseCommitted(ctx) {
+		buf, _ := json.Marshal(localizedError(ctx, err))
+		w.Header().Set(ErrorTrailer, string(buf))
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if headerer, ok := err.(httptransport.Headerer); ok {
+		for k := range headerer.Headers() {
+			w.Header().Set(k, headerer.Headers().Get(k))
+		}
+	}
+	e := withLogs(ctx, localizedError(ctx, err))
+	code := http.StatusOK
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		code = sc.StatusCode()
+	}
+	switch policy, _ := ctx.Value(contextKeyStatusPolicy).(StatusPolicy); policy {
+	case Always200:
+		code = http.StatusOK
+	case FromError:
+		if code != http.StatusOK {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(code)
+			return
+		}
+	}
+	encodeResponse(ctx, w, code, newResponse(ctx, nil, e))
+}
+
+// localizedError converts err into an error object with ToJSONRPCError, and
+// applies the ErrorLocalizer of the server in ctx, if any.
+func localizedError(ctx context.Context, err error) *Error {
+	e := ToJSONRPCError(err)
+	localize, ok := ctx.Value(contextKeyLocalizer).(func(context.Context, *Error) *Error)
+	if !ok {
+		return e
+	}
+	code := e.Code
+	if l := localize(ctx, e); l != nil {
+		e = l
+	}
+	e.Code = code
+	return e
+}
+
+// RequestHeader returns the headers of the HTTP request served by a Server in
+// ctx, which are otherwise only passed to RequestFuncs and handlers.
+func RequestHeader(ctx context.Context) http.Header {
+	h, _ := ctx.Value(contextKeyRequestHeader).(http.Header)
+	return h
+}
+
+// encodeResponse writes res to w with the given status code, indenting it if
+// the server was configured with PrettyResponses, and compressing it as
+// configured with CompressionMinSize. If res can't be encoded, nothing is
+// written and an encodeError is returned.
+func encodeResponse(ctx context.Context, w http.ResponseWriter, code int, res interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if indent, ok := ctx.Value(contextKeyIndent).(string); ok {
+		enc.SetIndent("", indent)
+	}
+	if err := enc.Encode(res); err != nil {
+		return encodeError{err}
+	}
+	return writeCompressed(ctx, w, code, buf.Bytes())
+}
+
+// encodeError is returned by encodeResponse for responses that can't be
+// encoded as JSON.
+type encodeError struct {
+	err error
+}
+
+func (e encodeError) Error() string { return e.err.Error() }
+
+// ErrorTrailer is the HTTP trailer of streamed responses in which
+// DefaultErrorEncoder reports, as a JSON-RPC error object, a failure that
+// occurred after the response was committed.
+const ErrorTrailer = "Jsonrpc-Error"
+
+// ResponseCommitted reports whether the response to the request in ctx has
+// already been started, so that an error encoder can no longer change its
+// status or body. Trailers declared by the server may still be set.
+func ResponseCommitted(ctx context.Context) bool {
+	committed, _ := ctx.Value(contextKeyCommitted).(bool)
+	return committed
+}
+
+type contextKey int
+
+const (
+	contextKeyIndent contextKey = iota
+	contextKeyResultMeta
+	contextKeyDefaultDecoder
+	contextKeyPrincipal
+	contextKeyRequestID
+	contextKeyQueueWait
+	contextKeyVersion
+	contextKeyLogs
+	contextKeyCompressMin
+	contextKeyStatusPolicy
+	contextKeyCommitted
+	contextKeyDefaultTimeout
+	contextKeyBegin
+	contextKeyRequestHeader
+	contextKeyLocalizer
+	contextKeyMethodInfo
+	contextKeyMethod
+	contextKeyNotifier
+	contextKeyAudit
+)
+
+// deadlineReader reads a request body under the read deadline that
+// DecodeTimeout set on the connection through ctl, and reports the deadline
+// expiring as errDecodeTimeout. Once the body is read, it lifts the deadline,
+// so that it can't cut the connection off while the request is served.
+type deadlineReader struct {
+	io.ReadCloser
+	ctl *http.ResponseController
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		r.ctl.SetReadDeadline(time.Time{})
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = errDecodeTimeout
+	}
+	return n, err
+}
+
+// maxBytesReader reads from a reader returned by http.MaxBytesReader with the
+// limit n, and reports exceeding the limit as errBodyTooLarge.
+type maxBytesReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if err != nil && err != io.EOF && r.read >= r.n {
+		err = errBodyTooLarge
+	}
+	return n, err
+}
+
+// prefixWriter keeps the first n bytes written to it and discards the rest.
+type prefixWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if room := w.n - len(w.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+type interceptingWriter struct {
+	http.ResponseWriter
+	code    int
+	written int64
+}
+
+// WriteHeader may not be explicitly called, so care must be taken to
+// initialize w.code to its default value of http.StatusOK.
+func (w *interceptingWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *interceptingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets an http.ResponseController reach the underlying writer, e.g.
+// to set the read deadline of DecodeTimeout.
+func (w *interceptingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Flush implements http.Flusher, so that streamed responses can be flushed
+// through the interceptingWriter.
+func (w *interceptingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
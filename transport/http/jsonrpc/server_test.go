@@ -0,0 +1,1384 @@
+package jsonrpc_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func addService(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(_ context.Context, request interface{}) (interface{}, error) {
+			ints := request.([]int)
+			return ints[0] + ints[1], nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var ints []int
+			err := json.Unmarshal(params, &ints)
+			return ints, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+		options...,
+	)
+}
+
+func post(t *testing.T, h http.Handler, body string) *http.Response {
+	t.Helper()
+	return postHeader(t, h, body, http.Header{})
+}
+
+func postHeader(t *testing.T, h http.Handler, body string, header http.Header) *http.Response {
+	t.Helper()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decodeResponse(t *testing.T, resp *http.Response) jsonrpc.Response {
+	t.Helper()
+	defer resp.Body.Close()
+	var res jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func errorCode(t *testing.T, res jsonrpc.Response) int {
+	t.Helper()
+	if res.Error == nil {
+		t.Fatalf("want error, have result %s", res.Result)
+	}
+	return res.Error.Code
+}
+
+func TestServerHappyPath(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`)
+	if want, have := http.StatusOK, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	res := decodeResponse(t, resp)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerMustPost(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := http.StatusMethodNotAllowed, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerUnsupportedMethodHandler(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.UnsupportedMethodHandler(func(w http.ResponseWriter, r *http.Request) {
+			jsonrpc.DefaultErrorEncoder(r.Context(), jsonrpc.Error{
+				Code:    jsonrpc.InvalidRequestError,
+				Message: r.Method + " not supported",
+			}, w)
+		}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	req, err := http.NewRequest("PUT", server.URL, strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := decodeResponse(t, resp)
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "PUT not supported", res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(),
+		"fail": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return nil, errors.New("dang") },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+		),
+	})
+	for _, tc := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"bad version", `{"jsonrpc":"1.0","method":"add","params":[1,2]}`, jsonrpc.InvalidRequestError},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"sub","params":[1,2]}`, jsonrpc.MethodNotFoundError},
+		{"endpoint error", `{"jsonrpc":"2.0","id":1,"method":"fail"}`, jsonrpc.InternalError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := decodeResponse(t, post(t, handler, tc.body))
+			if want, have := tc.code, errorCode(t, res); want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+func TestServerFinalizer(t *testing.T) {
+	var (
+		code int
+		size int64
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ServerFinalizer(func(ctx context.Context, c int, _ *http.Request) {
+			code = c
+			size, _ = ctx.Value(httptransport.ContextKeyResponseSize).(int64)
+		}),
+	)
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want, have := http.StatusOK, code; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := int64(len(body)), size; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerNotificationHeaders(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceAfter(func(ctx context.Context, h http.Header) context.Context {
+			h.Set("X-Trace-Id", "abc123")
+			return ctx
+		})),
+	})
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"add","params":[1,2]}`,
+		`[{"jsonrpc":"2.0","method":"add","params":[1,2]}]`,
+	} {
+		resp := post(t, handler, body)
+		resp.Body.Close()
+		if want, have := http.StatusNoContent, resp.StatusCode; want != have {
+			t.Errorf("%s: want status %d, have %d", body, want, have)
+		}
+		if want, have := "abc123", resp.Header.Get("X-Trace-Id"); want != have {
+			t.Errorf("%s: want header %q, have %q", body, want, have)
+		}
+	}
+}
+
+func TestServerFinalizers(t *testing.T) {
+	var codes []int
+	record := func(_ context.Context, code int, _ *http.Request) { codes = append(codes, code) }
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ServerFinalizer(record, record),
+		jsonrpc.ServerFinalizer(record),
+	)
+	post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`).Body.Close()
+	if want, have := []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestServerMaintenanceMode(t *testing.T) {
+	var enabled atomic.Bool
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.MaintenanceMode(&enabled, -32000, "down for maintenance"),
+	)
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+
+	enabled.Store(true)
+	res := decodeResponse(t, post(t, handler, body))
+	if want, have := -32000, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "down for maintenance", res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	enabled.Store(false)
+	res = decodeResponse(t, post(t, handler, body))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerWarmup(t *testing.T) {
+	var warm atomic.Bool
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.Warmup(warm.Load, jsonrpc.ServerBusyError),
+	)
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+
+	resp := post(t, handler, body)
+	if want, have := http.StatusServiceUnavailable, resp.StatusCode; want != have {
+		t.Errorf("want status %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.ServerBusyError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+
+	warm.Store(true)
+	res := decodeResponse(t, post(t, handler, body))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerPrettyResponses(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.PrettyResponses("  "),
+	)
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"result", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, "{\n  \"jsonrpc\": \"2.0\",\n  \"id\": 1,\n  \"result\": 3\n}\n"},
+		{"error", `{"jsonrpc":"2.0","method":"sub","params":[1,2],"id":1}`, "{\n  \"jsonrpc\": \"2.0\",\n  \"id\": 1,\n  \"error\": {\n    \"code\": -32601,\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := post(t, handler, tc.body)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !strings.HasPrefix(string(body), tc.want) {
+				t.Errorf("want prefix %q, have %q", tc.want, body)
+			}
+		})
+	}
+}
+
+func TestServerErroringBefore(t *testing.T) {
+	var invoked bool
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+			invoked = true
+			return ctx
+		}))},
+		jsonrpc.ServerErroringBefore(func(ctx context.Context, h http.Header) (context.Context, error) {
+			if h.Get("Authorization") == "" {
+				return ctx, jsonrpc.Error{Code: -32001, Message: "unauthorized"}
+			}
+			return ctx, nil
+		}),
+	)
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if want, have := -32001, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if invoked {
+		t.Error("service was invoked after the request was aborted")
+	}
+}
+
+func TestServerOverloadRetryAfter(t *testing.T) {
+	var (
+		entered = make(chan struct{})
+		done    = make(chan struct{})
+	)
+	slow := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) {
+			entered <- struct{}{}
+			<-done
+			return 1, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		jsonrpc.ServiceMaxConcurrent(1, 0, 1500*time.Millisecond),
+	)
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{"slow": slow}))
+	defer server.Close()
+
+	const body = `{"jsonrpc":"2.0","id":1,"method":"slow"}`
+	go func() {
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+	defer close(done)
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := http.StatusServiceUnavailable, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "2", resp.Header.Get("Retry-After"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := jsonrpc.ServerBusyError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerFromEndpoints(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.FromEndpoints(
+		map[string]endpoint.Endpoint{
+			"add": func(_ context.Context, request interface{}) (interface{}, error) {
+				ints := request.([]int)
+				return ints[0] + ints[1], nil
+			},
+			"mul": func(_ context.Context, request interface{}) (interface{}, error) {
+				ints := request.([]int)
+				return ints[0] * ints[1], nil
+			},
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var ints []int
+			err := json.Unmarshal(params, &ints)
+			return ints, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+	))
+	for method, want := range map[string]string{"add": "5", "mul": "6"} {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[2,3]}`))
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", method, res.Error)
+		}
+		if have := string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", method, want, have)
+		}
+	}
+}
+
+func TestServerRequireIDType(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		kind jsonrpc.IDKind
+		id   string
+		ok   bool
+	}{
+		{"any integer", jsonrpc.AnyID, `1`, true},
+		{"any string", jsonrpc.AnyID, `"a"`, true},
+		{"any null", jsonrpc.AnyID, `null`, true},
+		{"integer integer", jsonrpc.IntegerID, `42`, true},
+		{"integer float", jsonrpc.IntegerID, `4.2`, false},
+		{"integer string", jsonrpc.IntegerID, `"42"`, false},
+		{"integer null", jsonrpc.IntegerID, `null`, false},
+		{"string string", jsonrpc.StringID, `"a"`, true},
+		{"string integer", jsonrpc.StringID, `1`, false},
+		{"string null", jsonrpc.StringID, `null`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := jsonrpc.NewServer(
+				jsonrpc.ServiceMap{"add": addService()},
+				jsonrpc.RequireIDType(tc.kind),
+			)
+			res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":`+tc.id+`}`))
+			if tc.ok {
+				if res.Error != nil {
+					t.Errorf("unexpected error: %v", res.Error)
+				}
+				return
+			}
+			if want, have := jsonrpc.InvalidRequestError, errorCode(t, res); want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+func TestServerLogBodyOnDecodeError(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.LogBodyOnDecodeError(log.NewLogfmtLogger(&buf), 10),
+	)
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc": "2.0", oops}`))
+	if res.Error == nil {
+		t.Fatal("want error, have none")
+	}
+	if want, have := `body="{\"jsonrpc\""`, buf.String(); !strings.Contains(have, want) {
+		t.Errorf("want %s in log, have %q", want, have)
+	}
+
+	buf.Reset()
+	decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if buf.Len() != 0 {
+		t.Errorf("want nothing logged, have %q", buf.String())
+	}
+}
+
+func TestServerErrorData(t *testing.T) {
+	type params struct {
+		A int `json:"a"`
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"get": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+			jsonrpc.RequireFields(&params{}, "a"),
+			func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+		),
+	})
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"get","params":{}}`)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want, have := `"data":["a"]`, string(body); !strings.Contains(have, want) {
+		t.Errorf("want %s in %s", want, have)
+	}
+}
+
+func TestServerMaxResponseSize(t *testing.T) {
+	big := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) { return strings.Repeat("x", 100), nil },
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(), "big": big},
+		jsonrpc.MaxResponseSize(64),
+		jsonrpc.ServerErrorLogger(log.NewLogfmtLogger(&buf)),
+	)
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"big"}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "response too large", res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := "method=big", buf.String(); !strings.Contains(have, want) {
+		t.Errorf("want %s in log, have %q", want, have)
+	}
+
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if res.Error != nil {
+		t.Errorf("unexpected error: %v", res.Error)
+	}
+}
+
+func TestServerHTTPError(t *testing.T) {
+	limited := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) {
+			return nil, jsonrpc.HTTPError{
+				Code:    -32029,
+				Message: "slow down",
+				Status:  http.StatusTooManyRequests,
+				Header:  http.Header{"Retry-After": {"30"}},
+			}
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+	)
+	resp := post(t, jsonrpc.NewServer(jsonrpc.ServiceMap{"limited": limited}), `{"jsonrpc":"2.0","id":1,"method":"limited"}`)
+	if want, have := http.StatusTooManyRequests, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "30", resp.Header.Get("Retry-After"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	res := decodeResponse(t, resp)
+	if want, have := -32029, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "slow down", res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestServerDecodeTimeout(t *testing.T) {
+	slowAdd := addService(jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+		time.Sleep(150 * time.Millisecond)
+		return ctx
+	}))
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(), "slowAdd": slowAdd},
+		jsonrpc.DecodeTimeout(100*time.Millisecond),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:len(body)/2])
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("no response to a stalled body: %v", err)
+	}
+	if want, have := http.StatusRequestTimeout, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+
+	// The deadline is lifted once the body is read, so it doesn't bound the
+	// method itself.
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"slowAdd","params":[1,2]}`))
+	if res.Error != nil {
+		t.Errorf("unexpected error: %v", res.Error)
+	}
+}
+
+func TestServerFinalizerResponseHeaders(t *testing.T) {
+	var header http.Header
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService(jsonrpc.ServiceAfter(func(ctx context.Context, h http.Header) context.Context {
+			h.Set("X-Trace", "abc")
+			return ctx
+		}))},
+		jsonrpc.ServerFinalizer(func(ctx context.Context, _ int, _ *http.Request) {
+			header, _ = ctx.Value(httptransport.ContextKeyResponseHeaders).(http.Header)
+		}),
+	)
+	decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if want, have := "abc", header.Get("X-Trace"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := jsonrpc.ContentType, header.Get("Content-Type"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestServerDefaultParamsDecoder(t *testing.T) {
+	echo := func(_ context.Context, request interface{}) (interface{}, error) { return request, nil }
+	enc := func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) }
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"default": jsonrpc.NewService(echo, nil, enc),
+			"own": jsonrpc.NewService(echo, func(context.Context, json.RawMessage) (interface{}, error) {
+				return "own", nil
+			}, enc),
+		},
+		jsonrpc.DefaultParamsDecoder(func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			if len(params) == 0 {
+				return []interface{}{}, nil
+			}
+			var v interface{}
+			err := json.Unmarshal(params, &v)
+			return v, err
+		}),
+	)
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"default","params":[1]}`, `[1]`},
+		{`{"jsonrpc":"2.0","id":1,"method":"default"}`, `[]`},
+		{`{"jsonrpc":"2.0","id":1,"method":"own","params":[1]}`, `"own"`},
+	} {
+		res := decodeResponse(t, post(t, handler, tc.body))
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.body, res.Error)
+		}
+		if want, have := tc.want, string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", tc.body, want, have)
+		}
+	}
+
+	handler = jsonrpc.NewServer(jsonrpc.ServiceMap{"default": jsonrpc.NewService(echo, nil, enc)})
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"default"}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerIncludeServerTiming(t *testing.T) {
+	slow := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) {
+			time.Sleep(20 * time.Millisecond)
+			return 1, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"slow": slow}, jsonrpc.IncludeServerTiming())
+	begin := time.Now()
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"slow"}`)
+	elapsed := time.Since(begin).Seconds() * 1e3
+	defer resp.Body.Close()
+	var res struct {
+		Result       json.RawMessage `json:"result"`
+		ServerTimeMs *float64        `json:"serverTimeMs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ServerTimeMs == nil {
+		t.Fatal("want serverTimeMs, have none")
+	}
+	if have := *res.ServerTimeMs; have < 20 || have > elapsed {
+		t.Errorf("want between 20 and %.2f, have %.2f", elapsed, have)
+	}
+
+	resp = post(t, jsonrpc.NewServer(jsonrpc.ServiceMap{"slow": slow}), `{"jsonrpc":"2.0","id":1,"method":"slow"}`)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "serverTimeMs") {
+		t.Errorf("want no serverTimeMs by default, have %s", body)
+	}
+}
+
+func TestServerDedupNotifications(t *testing.T) {
+	var calls int32
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService(
+		jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+			atomic.AddInt32(&calls, 1)
+			return ctx
+		}),
+		jsonrpc.ServiceDedupNotifications(time.Minute, func(params json.RawMessage) string { return string(params) }),
+	)})
+
+	for i := 0; i < 3; i++ {
+		post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2]}`).Body.Close()
+	}
+	if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("notifications: want %d calls, have %d", want, have)
+	}
+
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[2,3]}`).Body.Close()
+	if want, have := int32(2), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("distinct notification: want %d calls, have %d", want, have)
+	}
+
+	for i := 0; i < 2; i++ {
+		post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`).Body.Close()
+	}
+	if want, have := int32(4), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("requests: want %d calls, have %d", want, have)
+	}
+}
+
+func TestServerDedupNotificationsWindow(t *testing.T) {
+	var calls int32
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService(
+		jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+			atomic.AddInt32(&calls, 1)
+			return ctx
+		}),
+		jsonrpc.ServiceDedupNotifications(100*time.Millisecond, func(params json.RawMessage) string { return string(params) }),
+	)})
+
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2]}`).Body.Close()
+	time.Sleep(60 * time.Millisecond)
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[2,3]}`).Body.Close()
+	time.Sleep(60 * time.Millisecond)
+	// [1,2] was seen a window ago, [2,3] within it, whether or not they've been pruned.
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2]}`).Body.Close()
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[2,3]}`).Body.Close()
+	if want, have := int32(3), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("want %d calls, have %d", want, have)
+	}
+}
+
+func TestServerValidateResponses(t *testing.T) {
+	user := func(v interface{}) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return v, nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		)
+	}
+	schema := json.RawMessage(`{
+		"type": "object",
+		"required": ["id", "name"],
+		"properties": {
+			"id": {"type": "integer"},
+			"name": {"type": "string"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"additionalProperties": false
+	}`)
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"good":    user(map[string]interface{}{"id": 1, "name": "a", "tags": []string{"x"}}),
+			"badType": user(map[string]interface{}{"id": "1", "name": "a"}),
+			"missing": user(map[string]interface{}{"id": 1}),
+			"extra":   user(map[string]interface{}{"id": 1, "name": "a", "age": 3}),
+			"badItem": user(map[string]interface{}{"id": 1, "name": "a", "tags": []int{1}}),
+		},
+		jsonrpc.ValidateResponses(map[string]json.RawMessage{
+			"good": schema, "badType": schema, "missing": schema, "extra": schema, "badItem": schema,
+		}),
+		jsonrpc.ServerErrorLogger(log.NewLogfmtLogger(&buf)),
+	)
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"good"}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	for _, method := range []string{"badType", "missing", "extra", "badItem"} {
+		buf.Reset()
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"`+method+`"}`))
+		if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", method, want, have)
+		}
+		if want, have := "method="+method, buf.String(); !strings.Contains(have, want) {
+			t.Errorf("%s: want %s in log, have %q", method, want, have)
+		}
+	}
+}
+
+func TestServerAcceptVersions(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.AcceptVersions(jsonrpc.Version, jsonrpc.Version1),
+	)
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"1.0", `{"method":"add","params":[1,2],"id":1}`, `{"result":3,"error":null,"id":1}`},
+		{"1.0 error", `{"method":"sub","params":[1,2],"id":"a"}`, `{"result":null,"error":{"code":-32601,"message":"Method not found: sub"},"id":"a"}`},
+		{"1.0 notification", `{"method":"add","params":[1,2],"id":null}`, ""},
+		{"2.0", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, `{"jsonrpc":"2.0","id":1,"result":3}`},
+		{"2.0 error", `{"jsonrpc":"2.0","method":"sub","params":[1,2],"id":1}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Method not found: sub"}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := post(t, handler, tc.body)
+			defer resp.Body.Close()
+			buf, _ := ioutil.ReadAll(resp.Body)
+			if want, have := tc.want, strings.TrimSpace(string(buf)); want != have {
+				t.Errorf("want %s, have %s", want, have)
+			}
+		})
+	}
+}
+
+func TestServerRejectsVersion1ByDefault(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	res := decodeResponse(t, post(t, handler, `{"method":"add","params":[1,2],"id":1}`))
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerStatusCodePolicy(t *testing.T) {
+	busy := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) {
+			return nil, jsonrpc.HTTPError{Code: jsonrpc.ServerBusyError, Status: http.StatusServiceUnavailable}
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	for _, tc := range []struct {
+		name     string
+		options  []jsonrpc.ServerOption
+		status   int
+		wantBody bool
+	}{
+		{"default", nil, http.StatusServiceUnavailable, true},
+		{"FromErrorButKeepBody", []jsonrpc.ServerOption{jsonrpc.StatusCodePolicy(jsonrpc.FromErrorButKeepBody)}, http.StatusServiceUnavailable, true},
+		{"FromError", []jsonrpc.ServerOption{jsonrpc.StatusCodePolicy(jsonrpc.FromError)}, http.StatusServiceUnavailable, false},
+		{"Always200", []jsonrpc.ServerOption{jsonrpc.StatusCodePolicy(jsonrpc.Always200)}, http.StatusOK, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"busy": busy}, tc.options...)
+			resp := post(t, handler, `{"jsonrpc":"2.0","method":"busy","id":1}`)
+			defer resp.Body.Close()
+			if want, have := tc.status, resp.StatusCode; want != have {
+				t.Errorf("want status %d, have %d", want, have)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if !tc.wantBody {
+				if len(body) > 0 {
+					t.Errorf("want no body, have %s", body)
+				}
+				return
+			}
+			var res jsonrpc.Response
+			if err := json.Unmarshal(body, &res); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := jsonrpc.ServerBusyError, errorCode(t, res); want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+type statusError struct{ status int }
+
+func (e statusError) Error() string   { return "status" }
+func (e statusError) StatusCode() int { return e.status }
+
+type headerError struct{ header http.Header }
+
+func (e headerError) Error() string        { return "header" }
+func (e headerError) Headers() http.Header { return e.header }
+
+func TestDefaultErrorEncoder(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		header http.Header
+		want   jsonrpc.Error
+	}{
+		{
+			name:   "plain",
+			err:    errors.New("boom"),
+			status: http.StatusOK,
+			want:   jsonrpc.Error{Code: jsonrpc.InternalError, Message: "boom"},
+		},
+		{
+			name:   "ErrorCoder",
+			err:    codedError{-32001},
+			status: http.StatusOK,
+			want:   jsonrpc.Error{Code: -32001, Message: "coded"},
+		},
+		{
+			name:   "StatusCoder",
+			err:    statusError{http.StatusTooManyRequests},
+			status: http.StatusTooManyRequests,
+			want:   jsonrpc.Error{Code: jsonrpc.InternalError, Message: "status"},
+		},
+		{
+			name:   "Headerer",
+			err:    headerError{http.Header{"X-Reason": {"quota"}}},
+			status: http.StatusOK,
+			header: http.Header{"X-Reason": {"quota"}},
+			want:   jsonrpc.Error{Code: jsonrpc.InternalError, Message: "header"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			jsonrpc.DefaultErrorEncoder(context.Background(), tc.err, rec)
+			if want, have := tc.status, rec.Code; want != have {
+				t.Errorf("want status %d, have %d", want, have)
+			}
+			if want, have := jsonrpc.ContentType, rec.Header().Get("Content-Type"); want != have {
+				t.Errorf("want Content-Type %q, have %q", want, have)
+			}
+			for k := range tc.header {
+				if want, have := tc.header.Get(k), rec.Header().Get(k); want != have {
+					t.Errorf("want %s %q, have %q", k, want, have)
+				}
+			}
+			var res jsonrpc.Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := jsonrpc.Version, res.JSONRPC; want != have {
+				t.Errorf("want version %q, have %q", want, have)
+			}
+			if res.Error == nil || *res.Error != tc.want {
+				t.Errorf("want %+v, have %+v", tc.want, res.Error)
+			}
+		})
+	}
+}
+
+func TestServerAllowGET(t *testing.T) {
+	type addRequest struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	add := jsonrpc.NewService(
+		func(_ context.Context, request interface{}) (interface{}, error) {
+			req := request.(*addRequest)
+			return req.A + req.B, nil
+		},
+		jsonrpc.DecodeForm(&addRequest{}),
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": add, "sub": add},
+		jsonrpc.AllowGET("add"),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?method=add&id=1&a=1&b=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := decodeResponse(t, resp)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	resp, err = http.Get(server.URL + "?method=sub&a=1&b=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := http.StatusMethodNotAllowed, resp.StatusCode; want != have {
+		t.Errorf("want status %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerEchoesID(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}, jsonrpc.RequireIDType(jsonrpc.IntegerID))
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"result", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":7}`, `"id":7`},
+		{"error", `{"jsonrpc":"2.0","method":"sub","params":[1,2],"id":7}`, `"id":7`},
+		{"null id", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":null}`, `"id":null`},
+		{"invalid id", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":"abc"}`, `"id":null`},
+		{"unparseable", `{"jsonrpc":"2.0","method":"add","id":7`, `"id":null`},
+		{"batch", `[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":8}]`, `"id":8`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := post(t, handler, tc.body)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if !strings.Contains(string(body), tc.want) {
+				t.Errorf("want %s in %s", tc.want, body)
+			}
+		})
+	}
+
+	// The id of a request whose params are streamed may follow them.
+	res := decodeResponse(t, post(t, jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(nil)}), `{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":7}`))
+	if want, have := `7`, string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+
+	handler = jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()})
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":"abc"}`)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want, have := `"id":"abc"`, string(body); !strings.Contains(have, want) {
+		t.Errorf("want %s in %s", want, have)
+	}
+}
+
+func TestServerNotifications(t *testing.T) {
+	var calls int32
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceBefore(func(ctx context.Context, _ http.Header) context.Context {
+			atomic.AddInt32(&calls, 1)
+			return ctx
+		})),
+	})
+	for _, tc := range []struct {
+		name, body string
+		calls      int32
+	}{
+		{"notification", `{"jsonrpc":"2.0","method":"add","params":[1,2]}`, 1},
+		{"failing notification", `{"jsonrpc":"2.0","method":"add","params":"x"}`, 2},
+		{"unknown method", `{"jsonrpc":"2.0","method":"sub","params":[1,2]}`, 2},
+		{"batch of notifications", `[{"jsonrpc":"2.0","method":"add","params":[1,2]},{"jsonrpc":"2.0","method":"sub"}]`, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := post(t, handler, tc.body)
+			defer resp.Body.Close()
+			if want, have := http.StatusNoContent, resp.StatusCode; want != have {
+				t.Errorf("want status %d, have %d", want, have)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if len(body) != 0 {
+				t.Errorf("want empty body, have %s", body)
+			}
+			if want, have := tc.calls, atomic.LoadInt32(&calls); want != have {
+				t.Errorf("want %d calls, have %d", want, have)
+			}
+		})
+	}
+
+	// Only the requests of a batch with ids are answered.
+	resp := post(t, handler, `[{"jsonrpc":"2.0","method":"add","params":[1,2]},{"jsonrpc":"2.0","method":"add","params":[3,4],"id":1}]`)
+	defer resp.Body.Close()
+	var res []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || string(res[0].Result) != "7" {
+		t.Errorf("want the result of the request only, have %+v", res)
+	}
+
+	// An invalid request is answered even without an id.
+	res1 := decodeResponse(t, post(t, handler, `{"jsonrpc":"1.5","method":"add","params":[1,2]}`))
+	if want, have := jsonrpc.InvalidRequestError, errorCode(t, res1); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerErrorIDs(t *testing.T) {
+	var maintenance atomic.Bool
+	failing := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) { return nil, errors.New("failed") },
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"add":   addService(),
+			"fail":  failing,
+			"count": countService(),
+			"sum":   sumService(nil),
+			"maint": addService(),
+		},
+		jsonrpc.ServerErroringBefore(func(ctx context.Context, h http.Header) (context.Context, error) {
+			if h.Get("X-Deny") != "" {
+				return ctx, jsonrpc.Error{Code: jsonrpc.UnauthorizedError}
+			}
+			return ctx, nil
+		}),
+		jsonrpc.MaintenanceMode(&maintenance, jsonrpc.ServerBusyError, "maintenance"),
+	)
+	for _, tc := range []struct {
+		name, body string
+		header     http.Header
+		maint      bool
+		code       int
+		id         string
+	}{
+		{"parse error", `{"jsonrpc":"2.0","id":7,`, nil, false, jsonrpc.ParseError, `null`},
+		{"wrong version", `{"jsonrpc":"3.0","id":7,"method":"add","params":[1,2]}`, nil, false, jsonrpc.InvalidRequestError, `7`},
+		{"method not found", `{"jsonrpc":"2.0","id":7,"method":"sub","params":[1,2]}`, nil, false, jsonrpc.MethodNotFoundError, `7`},
+		{"bad params", `{"jsonrpc":"2.0","id":"a","method":"add","params":"x"}`, nil, false, jsonrpc.InternalError, `"a"`},
+		{"handler error", `{"jsonrpc":"2.0","id":7,"method":"fail"}`, nil, false, jsonrpc.InternalError, `7`},
+		{"stream error", `{"jsonrpc":"2.0","id":7,"method":"count","params":"x"}`, nil, false, jsonrpc.InternalError, `7`},
+		{"params stream error", `{"jsonrpc":"2.0","method":"sum","id":7,"params":[1,"x"]}`, nil, false, jsonrpc.InternalError, `7`},
+		{"params stream error before id", `{"jsonrpc":"2.0","method":"sum","params":[1,"x"],"id":7}`, nil, false, jsonrpc.InternalError, `7`},
+		{"maintenance", `{"jsonrpc":"2.0","id":7,"method":"maint","params":[1,2]}`, nil, true, jsonrpc.ServerBusyError, `7`},
+		{"before body is read", `{"jsonrpc":"2.0","id":7,"method":"add","params":[1,2]}`, http.Header{"X-Deny": {"1"}}, false, jsonrpc.UnauthorizedError, `null`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			maintenance.Store(tc.maint)
+			header := tc.header
+			if header == nil {
+				header = http.Header{}
+			}
+			res := decodeResponse(t, postHeader(t, handler, tc.body, header))
+			if want, have := tc.code, errorCode(t, res); want != have {
+				t.Errorf("want code %d, have %d", want, have)
+			}
+			if want, have := tc.id, string(res.ID); want != have {
+				t.Errorf("want id %s, have %s", want, have)
+			}
+		})
+	}
+}
+
+func TestServerClosesHTTP10Connections(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}))
+	defer server.Close()
+
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+	for _, tc := range []struct {
+		name, connection string
+		closed           bool
+	}{
+		{"without keep-alive", "", true},
+		{"with keep-alive", "Keep-Alive", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			req := "POST / HTTP/1.0\r\nHost: test\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n"
+			if tc.connection != "" {
+				req += "Connection: " + tc.connection + "\r\n"
+			}
+			if _, err := io.WriteString(conn, req+"\r\n"+body); err != nil {
+				t.Fatal(err)
+			}
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if want, have := tc.closed, resp.Header.Get("Connection") == "close"; want != have {
+				t.Errorf("want Connection: close %v, have header %q", want, resp.Header.Get("Connection"))
+			}
+			if !tc.closed {
+				return
+			}
+			if _, err := br.ReadByte(); err != io.EOF {
+				t.Errorf("want connection closed, have %v", err)
+			}
+		})
+	}
+}
+
+func TestServerMaxBodySize(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}, jsonrpc.ServerMaxBodySize(64))
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2],"padding":"` + strings.Repeat("x", 100) + `"}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]},{"jsonrpc":"2.0","id":2,"method":"add","params":[3,4]}]`,
+	} {
+		resp := post(t, handler, body)
+		if want, have := http.StatusRequestEntityTooLarge, resp.StatusCode; want != have {
+			t.Errorf("want status %d, have %d", want, have)
+		}
+		if want, have := jsonrpc.RequestTooLargeError, errorCode(t, decodeResponse(t, resp)); want != have {
+			t.Errorf("want %d, have %d", want, have)
+		}
+	}
+}
+
+func TestServerParseErrorVsInvalidRequest(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}, jsonrpc.AcceptVersions(jsonrpc.Version, jsonrpc.Version1))
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{not json`, jsonrpc.ParseError},
+		{`{"jsonrpc":"2.0","method":"add"`, jsonrpc.ParseError},
+		{``, jsonrpc.ParseError},
+		{`{"foo":"bar"}`, jsonrpc.InvalidRequestError},
+		{`{"jsonrpc":"2.0","method":1,"id":1}`, jsonrpc.InvalidRequestError},
+		{`"add"`, jsonrpc.InvalidRequestError},
+	} {
+		if want, have := tc.code, errorCode(t, decodeResponse(t, post(t, handler, tc.body))); want != have {
+			t.Errorf("%s: want %d, have %d", tc.body, want, have)
+		}
+	}
+}
+
+func TestServerRequireContentType(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"add": addService()}, jsonrpc.ServerRequireContentType("application/json"))
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+	for _, tc := range []struct {
+		contentType string
+		code        int
+	}{
+		{"application/json", 0},
+		{"application/json; charset=utf-8", 0},
+		{"text/plain", jsonrpc.InvalidRequestError},
+		{"application/xml; charset=utf-8", jsonrpc.InvalidRequestError},
+	} {
+		resp := postHeader(t, handler, body, http.Header{"Content-Type": {tc.contentType}})
+		status := resp.StatusCode
+		res := decodeResponse(t, resp)
+		if tc.code == 0 {
+			if res.Error != nil {
+				t.Errorf("%q: unexpected error: %v", tc.contentType, res.Error)
+			}
+			continue
+		}
+		if want, have := tc.code, errorCode(t, res); want != have {
+			t.Errorf("%q: want %d, have %d", tc.contentType, want, have)
+		}
+		if want, have := http.StatusUnsupportedMediaType, status; want != have {
+			t.Errorf("%q: want status %d, have %d", tc.contentType, want, have)
+		}
+		if !strings.Contains(res.Error.Message, "Content-Type") {
+			t.Errorf("%q: want a message about the Content-Type, have %q", tc.contentType, res.Error.Message)
+		}
+	}
+}
+
+func TestServerVerifyBody(t *testing.T) {
+	key := []byte("secret")
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.VerifyBody(func(_ context.Context, raw []byte, h http.Header) error {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(raw)
+			signature, _ := hex.DecodeString(h.Get("X-Signature"))
+			if !hmac.Equal(signature, mac.Sum(nil)) {
+				return jsonrpc.HTTPError{Code: jsonrpc.UnauthorizedError, Message: "bad signature", Status: http.StatusUnauthorized}
+			}
+			return nil
+		}),
+	)
+	const body = `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`
+
+	res := decodeResponse(t, postHeader(t, handler, body, http.Header{"X-Signature": {sign(body)}}))
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	resp := postHeader(t, handler, body, http.Header{"X-Signature": {sign(body + " ")}})
+	if want, have := http.StatusUnauthorized, resp.StatusCode; want != have {
+		t.Errorf("want status %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.UnauthorizedError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServerRequestRewriter(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.RequestRewriter(func(_ context.Context, req jsonrpc.Request) (jsonrpc.Request, error) {
+			switch req.Method {
+			case "legacy.sum":
+				var p struct{ A, B int }
+				if err := json.Unmarshal(req.Params, &p); err != nil {
+					return req, jsonrpc.NewInvalidParamsError(err.Error())
+				}
+				req.Method = "add"
+				req.Params, _ = json.Marshal([]int{p.A, p.B})
+			case "legacy.gone":
+				return req, jsonrpc.Error{Code: -32099, Message: "gone"}
+			}
+			return req, nil
+		}),
+	)
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"legacy.sum","params":{"a":2,"b":5}}`))
+	if want, have := "7", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":2,"method":"legacy.gone"}`))
+	if want, have := -32099, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "2", string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+
+	resp := post(t, handler, `[{"jsonrpc":"2.0","id":3,"method":"legacy.sum","params":{"a":1,"b":1}},{"jsonrpc":"2.0","id":4,"method":"add","params":[3,4]}]`)
+	defer resp.Body.Close()
+	var batch []jsonrpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(batch); want != have {
+		t.Fatalf("want %d responses, have %d", want, have)
+	}
+	for i, want := range []string{"2", "7"} {
+		if have := string(batch[i].Result); want != have {
+			t.Errorf("response %d: want %s, have %s", i, want, have)
+		}
+	}
+}
+
+func TestServerUnencodableResult(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"broken": jsonrpc.NewService(
+				func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+				func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+				func(context.Context, interface{}) (json.RawMessage, error) {
+					return json.RawMessage(`{"ch":chan}`), nil
+				},
+			),
+		},
+		jsonrpc.ServerErrorLogger(log.NewLogfmtLogger(&buf)),
+	)
+
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"broken"}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"broken"}]`,
+	} {
+		buf.Reset()
+		res := decodeResponse(t, post(t, handler, body))
+		if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", body, want, have)
+		}
+		if want, have := "can't write response", buf.String(); !strings.Contains(have, want) {
+			t.Errorf("%s: want %q logged, have %q", body, want, have)
+		}
+	}
+}
+
+func TestServerErrorLoggerTransportFailures(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.ServerErrorLogger(log.NewLogfmtLogger(&buf)),
+	)
+	for _, tc := range []struct {
+		body, want string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"wp-login"}`, "method=wp-login"},
+		{`{"jsonrpc":"2.0","method":"phpinfo"}`, "method=phpinfo"},
+		{`[{"jsonrpc":"2.0","id":1,"method":"admin"}]`, "method=admin"},
+		{`{"jsonrpc":`, "err="},
+		{`[]`, "err="},
+	} {
+		buf.Reset()
+		post(t, handler, tc.body).Body.Close()
+		if have := buf.String(); !strings.Contains(have, tc.want) {
+			t.Errorf("%s: want %q logged, have %q", tc.body, tc.want, have)
+		}
+	}
+
+	buf.Reset()
+	post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`).Body.Close()
+	if buf.Len() != 0 {
+		t.Errorf("want nothing logged for a successful request, have %q", buf.String())
+	}
+}
@@ -0,0 +1,631 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/time/rate"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Handler serves a single JSON-RPC method. The header argument holds the
+// headers of the incoming HTTP request; the returned header is applied to the
+// HTTP response alongside the encoded result. Servers pass a context derived
+// from that of the HTTP request, so it's canceled when the client goes away.
+type Handler interface {
+	ServeJSONRPC(ctx context.Context, h http.Header, params json.RawMessage) (result json.RawMessage, rh http.Header, err error)
+}
+
+// ServiceMap maps JSON-RPC method names to the Handlers serving them.
+type ServiceMap map[string]Handler
+
+// FromEndpoints constructs a ServiceMap serving each endpoint under its key in
+// endpoints. Every endpoint is wrapped in a Service sharing the same codecs
+// and options, which suits sets of endpoints with uniform request and
+// response types.
+func FromEndpoints(
+	endpoints map[string]endpoint.Endpoint,
+	dec DecodeRequestFunc,
+	enc EncodeResponseFunc,
+	options ...ServiceOption,
+) ServiceMap {
+	sm := make(ServiceMap, len(endpoints))
+	for method, e := range endpoints {
+		sm[method] = NewService(e, dec, enc, options...)
+	}
+	return sm
+}
+
+// Service wraps an endpoint and implements Handler.
+type Service struct {
+	e              endpoint.Endpoint
+	dec            DecodeRequestFunc
+	enc            EncodeResponseFunc
+	before         []RequestFunc
+	erroringBefore []ErroringRequestFunc
+	after          []ServiceResponseFunc
+	afterResult    []ServiceResponseFuncWithResult
+	logger         log.Logger
+	slots          chan struct{}
+	pending        chan struct{}
+	retryAfter     time.Duration
+	queueWait      metrics.Histogram
+	strict         bool
+	resultMeta     bool
+	dedup          *dedup
+	desc           MethodDescriptor
+	info           *MethodInfo
+	versionField   string
+	versions       []string
+	fallback       func(context.Context, error) (interface{}, bool)
+	timeout        time.Duration
+	decrypt        func([]byte) ([]byte, error)
+	encrypt        func([]byte) ([]byte, error)
+	transform      func(context.Context, interface{}) (interface{}, error)
+	recover        bool
+	validationCode int
+	slaThreshold   time.Duration
+	slaBreach      func(context.Context, string, time.Duration)
+	reqCount       metrics.Counter
+	reqLatency     metrics.Histogram
+	tracer         opentracing.Tracer
+	forceSampled   map[string]bool
+	limiter        *rate.Limiter
+}
+
+// NewService constructs a new service, which implements Handler and wraps
+// the provided endpoint. If dec is nil, the service decodes params with the
+// server's DefaultParamsDecoder.
+func NewService(
+	e endpoint.Endpoint,
+	dec DecodeRequestFunc,
+	enc EncodeResponseFunc,
+	options ...ServiceOption,
+) *Service {
+	s := &Service{
+		e:      e,
+		dec:    dec,
+		enc:    enc,
+		logger: log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// ServiceOption sets an optional parameter for services.
+type ServiceOption func(*Service)
+
+// ServiceBefore functions are executed on the HTTP request headers before the
+// params are decoded.
+func ServiceBefore(before ...RequestFunc) ServiceOption {
+	return func(s *Service) { s.before = append(s.before, before...) }
+}
+
+// ServiceErroringBefore functions are executed on the HTTP request headers
+// after any ServiceBefore functions, but before the params are decoded. If
+// one of them returns an error, the params are not decoded and the endpoint
+// is not invoked; the error is returned from ServeJSONRPC instead.
+func ServiceErroringBefore(before ...ErroringRequestFunc) ServiceOption {
+	return func(s *Service) { s.erroringBefore = append(s.erroringBefore, before...) }
+}
+
+// ServiceAfter functions are executed on the HTTP response headers after the
+// endpoint is invoked, but before the result is encoded.
+func ServiceAfter(after ...ServiceResponseFunc) ServiceOption {
+	return func(s *Service) { s.after = append(s.after, after...) }
+}
+
+// ServiceAfterWithResult functions are executed on the HTTP response headers
+// and the endpoint's response, after the ServiceAfter functions. A response
+// provided by ServiceFallback, or transformed by ServiceResultTransformer, is
+// passed as such.
+func ServiceAfterWithResult(after ...ServiceResponseFuncWithResult) ServiceOption {
+	return func(s *Service) { s.afterResult = append(s.afterResult, after...) }
+}
+
+// ServiceErrorLogger is used to log non-terminal errors. By default, no errors
+// are logged.
+func ServiceErrorLogger(logger log.Logger) ServiceOption {
+	return func(s *Service) { s.logger = logger }
+}
+
+// ServiceStrictResults makes the service treat an endpoint returning both a
+// response and an error as a bug, answering with InternalError rather than
+// the endpoint's error. By default, the endpoint's error is returned.
+func ServiceStrictResults() ServiceOption {
+	return func(s *Service) { s.strict = true }
+}
+
+// ServiceResultMeta lets the endpoint attach metadata to its result with
+// SetResultMeta. The encoded result is then nested in an object of the form
+// {"result": ..., "meta": ..., "nextCursor": ...}, with meta and nextCursor
+// omitted if none was set. This shape isn't part of the JSON-RPC spec, so
+// it's off by default.
+func ServiceResultMeta() ServiceOption {
+	return func(s *Service) { s.resultMeta = true }
+}
+
+// ServiceDedupNotifications makes the service drop notifications, i.e.
+// requests without an id, whose params map to the same key as a notification
+// handled less than window ago. The dropped notification's endpoint isn't
+// invoked. Requests with an id are always handled.
+func ServiceDedupNotifications(window time.Duration, keyFn func(params json.RawMessage) string) ServiceOption {
+	return func(s *Service) {
+		s.dedup = &dedup{window: window, key: keyFn, seen: map[string]time.Time{}}
+	}
+}
+
+// ServiceMaxConcurrent limits the number of requests the service handles at
+// once to limit. Up to queue further requests wait for a free slot; requests
+// beyond that are rejected with ServerBusyError, an HTTP status of 503 and a
+// Retry-After header advising the client to back off for retryAfter.
+func ServiceMaxConcurrent(limit, queue int, retryAfter time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.slots = make(chan struct{}, limit)
+		s.pending = make(chan struct{}, limit+queue)
+		s.retryAfter = retryAfter
+	}
+}
+
+// ServiceRateLimit limits the rate at which the service handles requests to
+// that allowed by limiter. Requests the limiter doesn't allow right away are
+// rejected with RateLimitedError and an HTTP status of 429, without being
+// decoded. As each service has its own limiter, expensive methods can be
+// throttled harder than others.
+func ServiceRateLimit(limiter *rate.Limiter) ServiceOption {
+	return func(s *Service) { s.limiter = limiter }
+}
+
+// ServiceQueueWait observes, in seconds, the time each request spent waiting
+// for a concurrency slot of ServiceMaxConcurrent in h.
+func ServiceQueueWait(h metrics.Histogram) ServiceOption {
+	return func(s *Service) { s.queueWait = h }
+}
+
+// QueueWaitFromContext returns the time the request spent waiting for a
+// concurrency slot, if the service limits concurrent requests with
+// ServiceMaxConcurrent.
+func QueueWaitFromContext(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(contextKeyQueueWait).(time.Duration)
+	return d, ok
+}
+
+// ServiceFallback lets fallback replace the error of a failing endpoint with
+// a result, e.g. a cached or default value for a non-critical method. If
+// fallback returns true, its result is encoded as if the endpoint had
+// returned it; otherwise, the error is returned as usual. The endpoint's
+// error is logged either way.
+func ServiceFallback(fallback func(ctx context.Context, err error) (interface{}, bool)) ServiceOption {
+	return func(s *Service) { s.fallback = fallback }
+}
+
+// ServiceCrypto makes the service exchange params and results encrypted. The
+// params must be a JSON string holding the base64-encoded ciphertext, which
+// is decrypted with decrypt before anything else looks at the params; the
+// plaintext is the JSON params as usual. The encoded result is encrypted
+// with encrypt and sent as a JSON string holding the base64-encoded
+// ciphertext. Params that can't be decrypted yield an InvalidParamsError,
+// results that can't be encrypted an InternalError. It has no effect on a
+// ParamsStreamService.
+func ServiceCrypto(decrypt, encrypt func([]byte) ([]byte, error)) ServiceOption {
+	return func(s *Service) {
+		s.decrypt = decrypt
+		s.encrypt = encrypt
+	}
+}
+
+// ServiceTimeout bounds each invocation of the endpoint to d, overriding the
+// server's DefaultTimeout. The endpoint is expected to give up once its
+// context is done. An invocation that returns after its deadline fails with
+// InternalError, whatever the endpoint returned, and the timeout is logged.
+func ServiceTimeout(d time.Duration) ServiceOption {
+	return func(s *Service) { s.timeout = d }
+}
+
+// ServiceResultTransformer applies transform to the response of every
+// successful endpoint invocation, including responses provided by
+// ServiceFallback, before it's encoded, e.g. to rename the keys of a map. An
+// error returned by transform is logged and answered with InternalError.
+func ServiceResultTransformer(transform func(ctx context.Context, response interface{}) (interface{}, error)) ServiceOption {
+	return func(s *Service) { s.transform = transform }
+}
+
+// ServiceRecover makes the service recover from panics in its decoder,
+// endpoint, encoder and the functions around them, and answer the request with
+// an InternalError instead of crashing the HTTP handler. The panic is logged
+// with its stack trace, which isn't sent to the client.
+func ServiceRecover() ServiceOption {
+	return func(s *Service) { s.recover = true }
+}
+
+// ServiceValidationErrorCode sets the JSON-RPC error code of the requests
+// whose params the decoder rejects with a ValidationError, keeping them apart
+// from params it can't decode at all, which are rejected with a DecodeError
+// and answered with InvalidParamsError. By default, ValidationFailedError is
+// used.
+func ServiceValidationErrorCode(code int) ServiceOption {
+	return func(s *Service) { s.validationCode = code }
+}
+
+// ServiceSLA calls onBreach with the method being served and the time the
+// endpoint took whenever an invocation takes longer than threshold, whether
+// it succeeds or not, e.g. to raise an alert. The method is empty if the
+// request wasn't dispatched by a Server.
+func ServiceSLA(threshold time.Duration, onBreach func(ctx context.Context, method string, actual time.Duration)) ServiceOption {
+	return func(s *Service) {
+		s.slaThreshold = threshold
+		s.slaBreach = onBreach
+	}
+}
+
+// ServiceInstrumentation counts each invocation of the endpoint with
+// reqCount, and observes the seconds it took with reqLatency, both labeled
+// with "method", the method being served, and "error", "true" or "false"
+// depending on whether the endpoint failed. Either may be nil.
+func ServiceInstrumentation(reqCount metrics.Counter, reqLatency metrics.Histogram) ServiceOption {
+	return func(s *Service) {
+		s.reqCount = reqCount
+		s.reqLatency = reqLatency
+	}
+}
+
+// ServiceSupportedParamsVersions makes the service reject requests whose
+// params don't carry one of versions in the member field with
+// InvalidParamsError. The error's data lists the supported versions. The
+// version may be a JSON string or number; params that aren't an object, or
+// lack the member, are rejected too. It has no effect on a
+// ParamsStreamService.
+func ServiceSupportedParamsVersions(field string, versions ...string) ServiceOption {
+	return func(s *Service) {
+		s.versionField = field
+		s.versions = versions
+	}
+}
+
+// checkParamsVersion enforces ServiceSupportedParamsVersions.
+func (s Service) checkParamsVersion(params json.RawMessage) error {
+	var members map[string]json.RawMessage
+	json.Unmarshal(params, &members)
+	version := members[s.versionField]
+	var str string
+	if json.Unmarshal(version, &str) == nil {
+		version = json.RawMessage(str)
+	}
+	for _, v := range s.versions {
+		if len(version) > 0 && string(version) == v {
+			return nil
+		}
+	}
+	return Error{
+		Code:    InvalidParamsError,
+		Message: "unsupported params version",
+		Data:    s.versions,
+	}
+}
+
+// ServeJSONRPC implements Handler. The endpoint is invoked with a context
+// derived from ctx, so it observes the cancellation of the request. If the
+// endpoint returns an error, the error takes precedence and any response
+// returned along with it is discarded; as that usually points to a bug in the
+// endpoint, it's logged as a warning.
+func (s Service) ServeJSONRPC(ctx context.Context, h http.Header, params json.RawMessage) (json.RawMessage, http.Header, error) {
+	return s.handle(ctx, h, params, func(ctx context.Context) (json.RawMessage, http.Header, error) {
+		if s.decrypt != nil {
+			plain, err := s.decryptParams(params)
+			if err != nil {
+				s.logger.Log("err", err)
+				return nil, nil, invalidParamsError{errors.New("can't decrypt params")}
+			}
+			params = plain
+		}
+		result, rh, err := s.serve(ctx, h, func(ctx context.Context) (interface{}, error) {
+			if s.versionField != "" {
+				if err := s.checkParamsVersion(params); err != nil {
+					return nil, err
+				}
+			}
+			dec := s.dec
+			if dec == nil {
+				dec, _ = ctx.Value(contextKeyDefaultDecoder).(DecodeRequestFunc)
+			}
+			if dec == nil {
+				return nil, internalError{errors.New("no params decoder")}
+			}
+			return dec(ctx, params)
+		})
+		if err != nil || s.encrypt == nil {
+			return result, rh, err
+		}
+		cipher, err := s.encrypt(result)
+		if err != nil {
+			s.logger.Log("err", err)
+			return nil, nil, internalError{err}
+		}
+		result, _ = json.Marshal(base64.StdEncoding.EncodeToString(cipher))
+		return result, rh, nil
+	})
+}
+
+// handle serves a request with serve, guarded by the options that apply to
+// every request whatever way its params are read: ServiceTracing,
+// ServiceRecover, ServiceRateLimit and ServiceDedupNotifications, which keys
+// notifications by params.
+func (s Service) handle(ctx context.Context, h http.Header, params json.RawMessage, serve func(context.Context) (json.RawMessage, http.Header, error)) (result json.RawMessage, rh http.Header, err error) {
+	if s.tracer != nil {
+		var span opentracing.Span
+		ctx, span = s.startSpan(ctx, h)
+		defer func() { finishSpan(span, err) }() // after a recovered panic
+	}
+	if s.recover {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logger.Log("err", fmt.Sprintf("panic: %v", r), "stack", string(debug.Stack()))
+				result, rh, err = nil, nil, internalError{fmt.Errorf("panic: %v", r)}
+			}
+		}()
+	}
+
+	if s.limiter != nil && !s.limiter.Allow() {
+		return nil, nil, rateLimitedError{}
+	}
+
+	if s.dedup != nil && isNotification(ctx) && s.dedup.duplicate(params) {
+		return nil, http.Header{}, nil
+	}
+
+	return serve(ctx)
+}
+
+// decryptParams decrypts params given as a JSON string holding base64.
+func (s Service) decryptParams(params json.RawMessage) (json.RawMessage, error) {
+	var encoded string
+	if err := json.Unmarshal(params, &encoded); err != nil {
+		return nil, err
+	}
+	cipher, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(cipher)
+}
+
+// serve runs the request through the service, using decode to obtain the
+// request object for the endpoint.
+func (s Service) serve(ctx context.Context, h http.Header, decode func(context.Context) (interface{}, error)) (json.RawMessage, http.Header, error) {
+	if s.slots != nil {
+		begin := time.Now()
+		release, err := s.acquire(ctx)
+		if err != nil {
+			s.logger.Log("err", err)
+			return nil, nil, err
+		}
+		defer release()
+		wait := time.Since(begin)
+		ctx = context.WithValue(ctx, contextKeyQueueWait, wait)
+		if s.queueWait != nil {
+			s.queueWait.Observe(wait.Seconds())
+		}
+	}
+
+	meta := &resultMeta{}
+	ctx = context.WithValue(ctx, contextKeyResultMeta, meta)
+
+	for _, f := range s.before {
+		ctx = f(ctx, h)
+	}
+
+	for _, f := range s.erroringBefore {
+		var err error
+		if ctx, err = f(ctx, h); err != nil {
+			s.logger.Log("err", err)
+			return nil, nil, err
+		}
+	}
+
+	request, err := decode(ctx)
+	if err != nil {
+		s.logger.Log("err", err)
+		if ve, ok := err.(ValidationError); ok && s.validationCode != 0 {
+			err = Error{Code: s.validationCode, Message: ve.Error(), Data: ve.Data}
+		}
+		return nil, nil, err
+	}
+
+	begin := time.Now()
+	response, err := s.invoke(ctx, request)
+	actual := time.Since(begin)
+	method, _ := ctx.Value(contextKeyMethod).(string)
+	if s.slaBreach != nil && actual > s.slaThreshold {
+		s.slaBreach(ctx, method, actual)
+	}
+	if s.reqCount != nil || s.reqLatency != nil {
+		labelValues := []string{"method", method, "error", strconv.FormatBool(err != nil)}
+		if s.reqCount != nil {
+			s.reqCount.With(labelValues...).Add(1)
+		}
+		if s.reqLatency != nil {
+			s.reqLatency.With(labelValues...).Observe(actual.Seconds())
+		}
+	}
+	if err != nil {
+		if response == nil {
+			s.logger.Log("err", err)
+		} else {
+			s.logger.Log("err", err, "warn", "endpoint returned a response along with the error, response discarded")
+			if s.strict {
+				err = internalError{err}
+			}
+		}
+		var ok bool
+		if s.fallback != nil {
+			response, ok = s.fallback(ctx, err)
+		}
+		if !ok {
+			return nil, nil, err
+		}
+	}
+
+	if s.transform != nil {
+		if response, err = s.transform(ctx, response); err != nil {
+			s.logger.Log("err", err)
+			return nil, nil, internalError{err}
+		}
+	}
+
+	rh := http.Header{}
+	if meta.cursor != "" {
+		rh.Set("X-Next-Cursor", meta.cursor)
+	}
+	for _, f := range s.after {
+		ctx = f(ctx, rh)
+	}
+	for _, f := range s.afterResult {
+		ctx = f(ctx, rh, response)
+	}
+
+	result, err := s.enc(ctx, response)
+	if err != nil {
+		s.logger.Log("err", err)
+		return nil, nil, err
+	}
+
+	if s.resultMeta {
+		if result, err = json.Marshal(meta.wrap(result)); err != nil {
+			s.logger.Log("err", err)
+			return nil, nil, err
+		}
+	}
+
+	return result, rh, nil
+}
+
+// invoke calls the endpoint, bounded by the service's timeout or else the
+// server's DefaultTimeout.
+func (s Service) invoke(ctx context.Context, request interface{}) (interface{}, error) {
+	timeout := s.timeout
+	if timeout == 0 {
+		timeout, _ = ctx.Value(contextKeyDefaultTimeout).(time.Duration)
+	}
+	if timeout <= 0 {
+		return s.e(ctx, request)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	response, err := s.e(tctx, request)
+	if tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, timeoutError{timeout}
+	}
+	return response, err
+}
+
+// acquire waits for a free concurrency slot, failing immediately if the
+// queue of waiting requests is full.
+func (s Service) acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case s.pending <- struct{}{}:
+	default:
+		return nil, serverBusyError{s.retryAfter}
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots; <-s.pending }, nil
+	case <-ctx.Done():
+		<-s.pending
+		return nil, ctx.Err()
+	}
+}
+
+// RequestIDFromContext returns the id of the request being served, which is
+// empty for notifications. It's false when the request wasn't dispatched by a
+// Server.
+func RequestIDFromContext(ctx context.Context) (json.RawMessage, bool) {
+	id, ok := ctx.Value(contextKeyRequestID).(json.RawMessage)
+	return id, ok
+}
+
+// isNotification reports whether the request being served has no id. It's
+// false when the request wasn't dispatched by a Server.
+func isNotification(ctx context.Context) bool {
+	id, ok := RequestIDFromContext(ctx)
+	return ok && len(id) == 0
+}
+
+type dedup struct {
+	window    time.Duration
+	key       func(json.RawMessage) string
+	mtx       sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// duplicate reports whether params map to a key seen within the window, and
+// records the key otherwise. Keys seen longer ago are pruned at most once per
+// window, so that a burst of notifications doesn't scan them all each time.
+func (d *dedup) duplicate(params json.RawMessage) bool {
+	var (
+		key = d.key(params)
+		now = time.Now()
+	)
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if now.Sub(d.lastPrune) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+	if t, ok := d.seen[key]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// SetResultMeta attaches meta to the result of the request being served. It
+// has no effect unless the service was constructed with ServiceResultMeta.
+func SetResultMeta(ctx context.Context, meta interface{}) {
+	if m, ok := ctx.Value(contextKeyResultMeta).(*resultMeta); ok {
+		m.meta = meta
+	}
+}
+
+// SetNextCursor sets the cursor of the next page of the paginated result of
+// the request being served. The service sends it in the X-Next-Cursor
+// response header and, if constructed with ServiceResultMeta, as the
+// nextCursor member of the result envelope. An empty cursor means there's no
+// next page.
+func SetNextCursor(ctx context.Context, cursor string) {
+	if m, ok := ctx.Value(contextKeyResultMeta).(*resultMeta); ok {
+		m.cursor = cursor
+	}
+}
+
+type resultMeta struct {
+	meta   interface{}
+	cursor string
+}
+
+func (m *resultMeta) wrap(result json.RawMessage) interface{} {
+	return struct {
+		Result     json.RawMessage `json:"result"`
+		Meta       interface{}     `json:"meta,omitempty"`
+		NextCursor string          `json:"nextCursor,omitempty"`
+	}{result, m.meta, m.cursor}
+}
@@ -0,0 +1,784 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestServiceErroringBeforeSkipsDecode(t *testing.T) {
+	var (
+		errDenied = errors.New("denied")
+		decoded   bool
+		invoked   bool
+	)
+	service := jsonrpc.NewService(
+		func(context.Context, interface{}) (interface{}, error) { invoked = true; return nil, nil },
+		func(context.Context, json.RawMessage) (interface{}, error) { decoded = true; return nil, nil },
+		func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+		jsonrpc.ServiceErroringBefore(func(ctx context.Context, _ http.Header) (context.Context, error) {
+			return ctx, errDenied
+		}),
+	)
+	_, _, err := service.ServeJSONRPC(context.Background(), http.Header{}, json.RawMessage(`[1,2]`))
+	if want, have := errDenied, err; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+	if decoded {
+		t.Error("params were decoded after the request was aborted")
+	}
+	if invoked {
+		t.Error("endpoint was invoked after the request was aborted")
+	}
+}
+
+func TestServiceErroringBeforeContext(t *testing.T) {
+	type key struct{}
+	service := jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) { return ctx.Value(key{}), nil },
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		jsonrpc.ServiceErroringBefore(func(ctx context.Context, h http.Header) (context.Context, error) {
+			return context.WithValue(ctx, key{}, h.Get("X-User")), nil
+		}),
+	)
+	result, _, err := service.ServeJSONRPC(context.Background(), http.Header{"X-User": {"alice"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `"alice"`, string(result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServiceResultAndError(t *testing.T) {
+	errDang := errors.New("dang")
+	newService := func(logger log.Logger, options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return 1, errDang },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			append(options, jsonrpc.ServiceErrorLogger(logger))...,
+		)
+	}
+
+	var buf bytes.Buffer
+	result, _, err := newService(log.NewLogfmtLogger(&buf)).ServeJSONRPC(context.Background(), http.Header{}, nil)
+	if want, have := errDang, err; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+	if result != nil {
+		t.Errorf("want no result, have %s", result)
+	}
+	if !strings.Contains(buf.String(), "warn=") {
+		t.Errorf("want warning logged, have %q", buf.String())
+	}
+
+	_, _, err = newService(log.NewNopLogger(), jsonrpc.ServiceStrictResults()).ServeJSONRPC(context.Background(), http.Header{}, nil)
+	coder, ok := err.(jsonrpc.ErrorCoder)
+	if !ok {
+		t.Fatalf("want ErrorCoder, have %T", err)
+	}
+	if want, have := jsonrpc.InternalError, coder.ErrorCode(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestServiceResultMeta(t *testing.T) {
+	newService := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(ctx context.Context, request interface{}) (interface{}, error) {
+				if request.(bool) {
+					jsonrpc.SetResultMeta(ctx, map[string]string{"cursor": "abc"})
+				}
+				return []int{1, 2}, nil
+			},
+			func(_ context.Context, params json.RawMessage) (interface{}, error) {
+				var withMeta bool
+				err := json.Unmarshal(params, &withMeta)
+				return withMeta, err
+			},
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			options...,
+		)
+	}
+	for _, tc := range []struct {
+		name    string
+		service *jsonrpc.Service
+		params  string
+		want    string
+	}{
+		{"disabled", newService(), `true`, `[1,2]`},
+		{"meta set", newService(jsonrpc.ServiceResultMeta()), `true`, `{"result":[1,2],"meta":{"cursor":"abc"}}`},
+		{"meta unset", newService(jsonrpc.ServiceResultMeta()), `false`, `{"result":[1,2]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, _, err := tc.service.ServeJSONRPC(context.Background(), http.Header{}, json.RawMessage(tc.params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := tc.want, string(result); want != have {
+				t.Errorf("want %s, have %s", want, have)
+			}
+		})
+	}
+}
+
+type histogram struct {
+	mtx          sync.Mutex
+	labelValues  []string
+	observations []float64
+}
+
+func (h *histogram) With(labelValues ...string) metrics.Histogram {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.labelValues = append(h.labelValues, labelValues...)
+	return h
+}
+
+func (h *histogram) Observe(value float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.observations = append(h.observations, value)
+}
+
+func TestServiceQueueWait(t *testing.T) {
+	var (
+		entered = make(chan struct{}, 2)
+		done    = make(chan struct{})
+		waits   = make(chan time.Duration, 2)
+		h       = &histogram{}
+	)
+	service := jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			wait, _ := jsonrpc.QueueWaitFromContext(ctx)
+			waits <- wait
+			entered <- struct{}{}
+			<-done
+			return nil, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(context.Context, interface{}) (json.RawMessage, error) { return nil, nil },
+		jsonrpc.ServiceMaxConcurrent(1, 1, time.Second),
+		jsonrpc.ServiceQueueWait(h),
+	)
+
+	var wg sync.WaitGroup
+	serve := func() {
+		defer wg.Done()
+		service.ServeJSONRPC(context.Background(), http.Header{}, nil)
+	}
+	wg.Add(2)
+	go serve()
+	<-entered
+	go serve()
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	first, second := <-waits, <-waits
+	if first > 10*time.Millisecond {
+		t.Errorf("first request: want no wait, have %v", first)
+	}
+	if second < 50*time.Millisecond {
+		t.Errorf("queued request: want at least 50ms wait, have %v", second)
+	}
+	if want, have := 2, len(h.observations); want != have {
+		t.Fatalf("want %d observations, have %d", want, have)
+	}
+	if want, have := second.Seconds(), h.observations[1]; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestServiceSupportedParamsVersions(t *testing.T) {
+	echo := jsonrpc.NewService(
+		func(_ context.Context, request interface{}) (interface{}, error) { return request, nil },
+		jsonrpc.DecodeNamedRaw(),
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		jsonrpc.ServiceSupportedParamsVersions("v", "2", "3"),
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"echo": echo})
+
+	for _, params := range []string{`{"v":"2"}`, `{"v":3}`} {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"echo","params":`+params+`,"id":1}`))
+		if res.Error != nil {
+			t.Errorf("%s: unexpected error: %v", params, res.Error)
+		}
+	}
+	for _, params := range []string{`{"v":"1"}`, `{"v":1}`, `{"x":"2"}`, `["2"]`, `{"v":null}`} {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"echo","params":`+params+`,"id":1}`))
+		if want, have := jsonrpc.InvalidParamsError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", params, want, have)
+			continue
+		}
+		if want, have := []interface{}{"2", "3"}, res.Error.Data; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: want data %v, have %v", params, want, have)
+		}
+	}
+}
+
+func TestServiceFallback(t *testing.T) {
+	var (
+		errUnavailable = errors.New("upstream unavailable")
+		gotErr         error
+	)
+	quote := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return nil, errUnavailable },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			options...,
+		)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"cached": quote(jsonrpc.ServiceFallback(func(_ context.Context, err error) (interface{}, bool) {
+			gotErr = err
+			return 42, true
+		})),
+		"declined": quote(jsonrpc.ServiceFallback(func(context.Context, error) (interface{}, bool) {
+			return nil, false
+		})),
+	})
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"cached","id":1}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "42", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := errUnavailable, gotErr; want != have {
+		t.Errorf("want fallback called with %v, have %v", want, have)
+	}
+
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"declined","id":1}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := errUnavailable.Error(), res.Error.Message; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestServerDefaultTimeout(t *testing.T) {
+	// deadline reports the time left to the endpoint when it's invoked.
+	deadline := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				d, ok := ctx.Deadline()
+				if !ok {
+					return 0, nil
+				}
+				return time.Until(d).Seconds(), nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			options...,
+		)
+	}
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{
+			"default":  deadline(),
+			"override": deadline(jsonrpc.ServiceTimeout(time.Hour)),
+		},
+		jsonrpc.DefaultTimeout(time.Minute),
+	)
+	for method, want := range map[string]float64{"default": 60, "override": 3600} {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"`+method+`","id":1}`))
+		var have float64
+		if err := json.Unmarshal(res.Result, &have); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if have <= want-10 || have > want {
+			t.Errorf("%s: want deadline in about %vs, have %vs", method, want, have)
+		}
+	}
+}
+
+func TestServiceCrypto(t *testing.T) {
+	xor := func(key byte) func([]byte) ([]byte, error) {
+		return func(p []byte) ([]byte, error) {
+			out := make([]byte, len(p))
+			for i := range p {
+				out[i] = p[i] ^ key
+			}
+			return out, nil
+		}
+	}
+	seal := func(plain string) string {
+		cipher, _ := xor(0x5a)([]byte(plain))
+		quoted, _ := json.Marshal(base64.StdEncoding.EncodeToString(cipher))
+		return string(quoted)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceCrypto(xor(0x5a), xor(0x5a))),
+		"broken": addService(jsonrpc.ServiceCrypto(xor(0x5a), func([]byte) ([]byte, error) {
+			return nil, errors.New("no key")
+		})),
+	})
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"add","params":`+seal(`[1,2]`)+`,"id":1}`))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	var encoded string
+	if err := json.Unmarshal(res.Result, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := xor(0x5a)(cipher)
+	if want, have := "3", string(plain); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	for _, params := range []string{`[1,2]`, `"!!not base64"`} {
+		res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"add","params":`+params+`,"id":1}`))
+		if want, have := jsonrpc.InvalidParamsError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", params, want, have)
+		}
+	}
+
+	res = decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"broken","params":`+seal(`[1,2]`)+`,"id":1}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestSetNextCursor(t *testing.T) {
+	list := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				jsonrpc.SetNextCursor(ctx, "page-2")
+				return []int{1, 2}, nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			options...,
+		)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"list":         list(),
+		"listEnvelope": list(jsonrpc.ServiceResultMeta()),
+	})
+
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"list","id":1}`)
+	if want, have := "page-2", resp.Header.Get("X-Next-Cursor"); want != have {
+		t.Errorf("want cursor %q, have %q", want, have)
+	}
+	if want, have := `[1,2]`, string(decodeResponse(t, resp).Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	resp = post(t, handler, `{"jsonrpc":"2.0","method":"listEnvelope","id":1}`)
+	if want, have := "page-2", resp.Header.Get("X-Next-Cursor"); want != have {
+		t.Errorf("want cursor %q, have %q", want, have)
+	}
+	if want, have := `{"result":[1,2],"nextCursor":"page-2"}`, string(decodeResponse(t, resp).Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServiceResultTransformer(t *testing.T) {
+	camelCase := func(_ context.Context, response interface{}) (interface{}, error) {
+		m, ok := response.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("not an object")
+		}
+		out := map[string]interface{}{}
+		for k, v := range m {
+			parts := strings.Split(k, "_")
+			for i := 1; i < len(parts); i++ {
+				parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+			}
+			out[strings.Join(parts, "")] = v
+		}
+		return out, nil
+	}
+	svc := func(response interface{}) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return response, nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceResultTransformer(camelCase),
+		)
+	}
+
+	result, _, err := svc(map[string]interface{}{"first_name": "Ada", "last_login_at": 1}).ServeJSONRPC(context.Background(), http.Header{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `{"firstName":"Ada","lastLoginAt":1}`, string(result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	_, _, err = svc(42).ServeJSONRPC(context.Background(), http.Header{}, nil)
+	if ec, ok := err.(jsonrpc.ErrorCoder); !ok || ec.ErrorCode() != jsonrpc.InternalError {
+		t.Errorf("want InternalError, have %v", err)
+	}
+}
+
+func TestServiceTimeoutExceeded(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"slow": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				time.Sleep(50 * time.Millisecond)
+				return "done", nil
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceTimeout(10*time.Millisecond),
+			jsonrpc.ServiceErrorLogger(log.NewLogfmtLogger(&buf)),
+		),
+	})
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"slow","id":1}`))
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "timeout", buf.String(); !strings.Contains(have, want) {
+		t.Errorf("want %q logged, have %q", want, have)
+	}
+}
+
+func TestServiceObservesClientCancellation(t *testing.T) {
+	var (
+		started  = make(chan struct{})
+		canceled = make(chan error, 1)
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"wait": jsonrpc.NewService(
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				close(started)
+				select {
+				case <-ctx.Done():
+					canceled <- ctx.Err()
+					return nil, ctx.Err()
+				case <-time.After(5 * time.Second):
+					canceled <- nil
+					return nil, nil
+				}
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceTimeout(time.Minute),
+		),
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader(`{"jsonrpc":"2.0","method":"wait","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		t.Error("want the request canceled, have a response")
+	}
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("want the endpoint context canceled, have %v", err)
+	}
+}
+
+func TestServiceRecover(t *testing.T) {
+	var buf bytes.Buffer
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"boom": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return "unreachable", nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { panic("decoder exploded") },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceRecover(),
+			jsonrpc.ServiceErrorLogger(log.NewLogfmtLogger(&buf)),
+		),
+	})
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"boom","id":1}`)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	var res jsonrpc.Response
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "1", string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+	if strings.Contains(string(body), "exploded") || strings.Contains(string(body), "goroutine") {
+		t.Errorf("panic leaked into response: %s", body)
+	}
+	for _, want := range []string{"decoder exploded", "goroutine"} {
+		if have := buf.String(); !strings.Contains(have, want) {
+			t.Errorf("want %q logged, have %q", want, have)
+		}
+	}
+}
+
+func TestServiceDecodeVsValidationErrors(t *testing.T) {
+	type transfer struct {
+		Amount int `json:"amount"`
+	}
+	dec := func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		var req transfer
+		if err := json.Unmarshal(params, &req); err != nil {
+			return nil, jsonrpc.DecodeError{Err: err}
+		}
+		if req.Amount <= 0 {
+			return nil, jsonrpc.ValidationError{Err: errors.New("amount must be positive"), Data: map[string]string{"amount": "positive"}}
+		}
+		return req, nil
+	}
+	newService := func(options ...jsonrpc.ServiceOption) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(_ context.Context, request interface{}) (interface{}, error) {
+				return request.(transfer).Amount, nil
+			},
+			dec,
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			options...,
+		)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"transfer":  newService(),
+		"transfer2": newService(jsonrpc.ServiceValidationErrorCode(-32050)),
+	})
+
+	for _, tc := range []struct {
+		body string
+		code int
+		data string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"transfer","params":{"amount":"ten"}}`, jsonrpc.InvalidParamsError, ""},
+		{`{"jsonrpc":"2.0","id":1,"method":"transfer","params":{"amount":-5}}`, jsonrpc.ValidationFailedError, `{"amount":"positive"}`},
+		{`{"jsonrpc":"2.0","id":1,"method":"transfer2","params":{"amount":"ten"}}`, jsonrpc.InvalidParamsError, ""},
+		{`{"jsonrpc":"2.0","id":1,"method":"transfer2","params":{"amount":-5}}`, -32050, `{"amount":"positive"}`},
+	} {
+		resp := post(t, handler, tc.body)
+		var res struct {
+			Error struct {
+				Code    int             `json:"code"`
+				Message string          `json:"message"`
+				Data    json.RawMessage `json:"data"`
+			} `json:"error"`
+		}
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := tc.code, res.Error.Code; want != have {
+			t.Errorf("%s: want code %d, have %d", tc.body, want, have)
+		}
+		if want, have := tc.data, string(res.Error.Data); want != have {
+			t.Errorf("%s: want data %s, have %s", tc.body, want, have)
+		}
+	}
+}
+
+func TestServiceAfterWithResult(t *testing.T) {
+	type order struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	var plain bool
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"order": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return order{"o-42", "shipped"}, nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceAfter(func(ctx context.Context, _ http.Header) context.Context {
+				plain = true
+				return ctx
+			}),
+			jsonrpc.ServiceAfterWithResult(func(ctx context.Context, h http.Header, response interface{}) context.Context {
+				id, _ := jsonrpc.RequestIDFromContext(ctx)
+				h.Set("X-Correlation", string(id)+"/"+response.(order).ID)
+				return ctx
+			}),
+		),
+	})
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"order","id":7}`)
+	resp.Body.Close()
+	if want, have := "7/o-42", resp.Header.Get("X-Correlation"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if !plain {
+		t.Error("want ServiceAfter still executed")
+	}
+}
+
+func TestServiceSLA(t *testing.T) {
+	type breach struct {
+		method string
+		actual time.Duration
+	}
+	var (
+		mtx      sync.Mutex
+		breaches []breach
+	)
+	onBreach := func(_ context.Context, method string, actual time.Duration) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		breaches = append(breaches, breach{method, actual})
+	}
+	service := func(delay time.Duration, err error) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) {
+				time.Sleep(delay)
+				return "done", err
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceSLA(20*time.Millisecond, onBreach),
+		)
+	}
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"fast":       service(0, nil),
+		"slow":       service(30*time.Millisecond, nil),
+		"slowFailed": service(30*time.Millisecond, errors.New("failed")),
+	})
+	for _, method := range []string{"fast", "slow", "slowFailed"} {
+		post(t, handler, `{"jsonrpc":"2.0","method":"`+method+`","id":1}`).Body.Close()
+	}
+
+	if want, have := 2, len(breaches); want != have {
+		t.Fatalf("want %d breaches, have %+v", want, breaches)
+	}
+	for i, method := range []string{"slow", "slowFailed"} {
+		if want, have := method, breaches[i].method; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+		if breaches[i].actual < 30*time.Millisecond {
+			t.Errorf("%s: want at least 30ms, have %v", method, breaches[i].actual)
+		}
+	}
+}
+
+func TestServiceRateLimit(t *testing.T) {
+	const burst = 3
+	var invoked int
+	service := func(limiter *rate.Limiter) *jsonrpc.Service {
+		return jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { invoked++; return "done", nil },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceRateLimit(limiter),
+		)
+	}
+	// Limiters refilling once an hour allow no more than burst calls in a row.
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"expensive": service(rate.NewLimiter(rate.Every(time.Hour), burst)),
+		"cheap":     service(rate.NewLimiter(rate.Every(time.Hour), burst+1)),
+	})
+
+	for i := 0; i < burst; i++ {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"expensive","id":1}`))
+		if res.Error != nil {
+			t.Fatalf("call %d: %v", i+1, res.Error)
+		}
+	}
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"expensive","id":1}`)
+	if want, have := http.StatusTooManyRequests, resp.StatusCode; want != have {
+		t.Errorf("want status %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.RateLimitedError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want code %d, have %d", want, have)
+	}
+	if want, have := burst, invoked; want != have {
+		t.Errorf("want %d invocations, have %d", want, have)
+	}
+
+	// The other method has a limiter of its own.
+	for i := 0; i <= burst; i++ {
+		res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"cheap","id":1}`))
+		if res.Error != nil {
+			t.Fatalf("cheap call %d: %v", i+1, res.Error)
+		}
+	}
+}
+
+// counter sums the deltas added through it by label values.
+type counter struct {
+	mtx    sync.Mutex
+	counts map[string]float64
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter {
+	return labeledCounter{c, strings.Join(labelValues, ",")}
+}
+
+func (c *counter) Add(delta float64) { c.With().Add(delta) }
+
+type labeledCounter struct {
+	c      *counter
+	labels string
+}
+
+func (lc labeledCounter) With(labelValues ...string) metrics.Counter {
+	return lc.c.With(append(strings.Split(lc.labels, ","), labelValues...)...)
+}
+
+func (lc labeledCounter) Add(delta float64) {
+	lc.c.mtx.Lock()
+	defer lc.c.mtx.Unlock()
+	lc.c.counts[lc.labels] += delta
+}
+
+func TestServiceInstrumentation(t *testing.T) {
+	var (
+		c = &counter{counts: map[string]float64{}}
+		h = &histogram{}
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceInstrumentation(c, h)),
+		"fail": jsonrpc.NewService(
+			func(context.Context, interface{}) (interface{}, error) { return nil, errors.New("failed") },
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceInstrumentation(c, h),
+		),
+	})
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`,
+		`{"jsonrpc":"2.0","method":"add","params":[3,4],"id":2}`,
+		`{"jsonrpc":"2.0","method":"fail","id":3}`,
+	} {
+		post(t, handler, body).Body.Close()
+	}
+
+	want := map[string]float64{"method,add,error,false": 2, "method,fail,error,true": 1}
+	if !reflect.DeepEqual(want, c.counts) {
+		t.Errorf("want %v, have %v", want, c.counts)
+	}
+	if want, have := 3, len(h.observations); want != have {
+		t.Errorf("want %d observations, have %d", want, have)
+	}
+	if want, have := []string{"method", "add", "error", "false"}, h.labelValues[:4]; !reflect.DeepEqual(want, have) {
+		t.Errorf("want labels %v, have %v", want, have)
+	}
+}
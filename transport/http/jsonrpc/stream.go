@@ -0,0 +1,223 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// StreamHandler is implemented by handlers whose result is a JSON array that
+// can be written to the client element by element, without buffering the
+// whole result. Servers prefer ServeJSONRPCStream over ServeJSONRPC for
+// handlers implementing it.
+//
+// ServeJSONRPCStream passes each encoded element of the result to emit, in
+// order. An error returned before the first element is emitted is handled
+// like any other error; once an element has been emitted the response is
+// committed, and an error can only cut it short.
+type StreamHandler interface {
+	Handler
+	ServeJSONRPCStream(ctx context.Context, h http.Header, params json.RawMessage, emit func(json.RawMessage) error) error
+}
+
+// StreamService wraps an endpoint returning a channel of result elements and
+// implements StreamHandler.
+type StreamService struct {
+	e      endpoint.Endpoint
+	dec    DecodeRequestFunc
+	enc    EncodeResponseFunc
+	logger log.Logger
+	active metrics.Gauge
+}
+
+// NewStreamService constructs a new stream service, which implements
+// StreamHandler and wraps the provided endpoint. The endpoint must return a
+// <-chan interface{} carrying the elements of the result, which are encoded
+// one by one with enc; the endpoint closes the channel when it's done. It may
+// send an error value on the channel to abort the stream. The endpoint should
+// stop sending once the request context is done.
+func NewStreamService(
+	e endpoint.Endpoint,
+	dec DecodeRequestFunc,
+	enc EncodeResponseFunc,
+	options ...StreamServiceOption,
+) *StreamService {
+	s := &StreamService{
+		e:      e,
+		dec:    dec,
+		enc:    enc,
+		logger: log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// StreamServiceOption sets an optional parameter for stream services.
+type StreamServiceOption func(*StreamService)
+
+// StreamServiceErrorLogger is used to log non-terminal errors. By default, no
+// errors are logged.
+func StreamServiceErrorLogger(logger log.Logger) StreamServiceOption {
+	return func(s *StreamService) { s.logger = logger }
+}
+
+// StreamServiceActiveStreams tracks the number of streams being served in g.
+func StreamServiceActiveStreams(g metrics.Gauge) StreamServiceOption {
+	return func(s *StreamService) { s.active = g }
+}
+
+// ServeJSONRPCStream implements StreamHandler. The endpoint is invoked with a
+// context that's canceled as soon as the stream ends for whatever reason,
+// including the client going away or a failure to emit an element, which is
+// the endpoint's signal to stop producing elements.
+func (s StreamService) ServeJSONRPCStream(ctx context.Context, h http.Header, params json.RawMessage, emit func(json.RawMessage) error) error {
+	if s.active != nil {
+		s.active.Add(1)
+		defer s.active.Add(-1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	request, err := s.dec(ctx, params)
+	if err != nil {
+		s.logger.Log("err", err)
+		return err
+	}
+
+	response, err := s.e(ctx, request)
+	if err != nil {
+		s.logger.Log("err", err)
+		return err
+	}
+
+	var elements <-chan interface{}
+	switch c := response.(type) {
+	case <-chan interface{}:
+		elements = c
+	case chan interface{}:
+		elements = c
+	default:
+		err := internalError{errStreamResponse}
+		s.logger.Log("err", err.err)
+		return err
+	}
+
+	for {
+		select {
+		case v, ok := <-elements:
+			if !ok {
+				return nil
+			}
+			if err, ok := v.(error); ok {
+				s.logger.Log("err", err)
+				return err
+			}
+			element, err := s.enc(ctx, v)
+			if err != nil {
+				s.logger.Log("err", err)
+				return err
+			}
+			if err := emit(element); err != nil {
+				s.logger.Log("err", err)
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ServeJSONRPC implements Handler by collecting the streamed elements into a
+// single array.
+func (s StreamService) ServeJSONRPC(ctx context.Context, h http.Header, params json.RawMessage) (json.RawMessage, http.Header, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	err := s.ServeJSONRPCStream(ctx, h, params, func(element json.RawMessage) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		_, err := buf.Write(element)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), http.Header{}, nil
+}
+
+// DecodeParamsStreamFunc extracts a user-domain request object from the params
+// of a JSON-RPC request while they're read from the request body. The decoder
+// is positioned at the params value, which the function must consume
+// entirely, e.g. element by element with Token, More and Decode.
+type DecodeParamsStreamFunc func(context.Context, *json.Decoder) (request interface{}, err error)
+
+// ParamsStreamHandler is implemented by handlers able to decode params
+// straight from the request body, without buffering them. Servers call
+// ServeJSONRPCParams for requests whose jsonrpc, method and id members precede
+// params, and ServeJSONRPC with the buffered params otherwise, including for
+// notifications.
+type ParamsStreamHandler interface {
+	Handler
+	ServeJSONRPCParams(ctx context.Context, h http.Header, dec *json.Decoder) (result json.RawMessage, rh http.Header, err error)
+}
+
+// ParamsStreamService wraps an endpoint whose params are decoded from the
+// request body as they're read, and implements ParamsStreamHandler.
+type ParamsStreamService struct {
+	Service
+	dec DecodeParamsStreamFunc
+}
+
+// NewParamsStreamService constructs a new params stream service, which
+// implements ParamsStreamHandler and wraps the provided endpoint. Apart from
+// the params decoder, it behaves like a Service constructed with the same
+// options, except for ServiceCrypto and ServiceSupportedParamsVersions, which
+// need the params up front. Notifications are deduplicated by their params
+// under ServiceDedupNotifications, so those are buffered.
+func NewParamsStreamService(
+	e endpoint.Endpoint,
+	dec DecodeParamsStreamFunc,
+	enc EncodeResponseFunc,
+	options ...ServiceOption,
+) *ParamsStreamService {
+	return &ParamsStreamService{
+		Service: *NewService(e, nil, enc, options...),
+		dec:     dec,
+	}
+}
+
+// ServeJSONRPCParams implements ParamsStreamHandler.
+func (s ParamsStreamService) ServeJSONRPCParams(ctx context.Context, h http.Header, dec *json.Decoder) (json.RawMessage, http.Header, error) {
+	var params json.RawMessage
+	if s.dedup != nil && isNotification(ctx) {
+		if err := dec.Decode(&params); err != nil {
+			return nil, nil, invalidParamsError{err}
+		}
+		dec = json.NewDecoder(bytes.NewReader(params))
+	}
+	return s.handle(ctx, h, params, s.serveDecoder(h, dec))
+}
+
+// ServeJSONRPC implements Handler by decoding the buffered params.
+func (s ParamsStreamService) ServeJSONRPC(ctx context.Context, h http.Header, params json.RawMessage) (json.RawMessage, http.Header, error) {
+	return s.handle(ctx, h, params, s.serveDecoder(h, json.NewDecoder(bytes.NewReader(params))))
+}
+
+// serveDecoder returns the function serving a request whose params are
+// decoded from dec.
+func (s ParamsStreamService) serveDecoder(h http.Header, dec *json.Decoder) func(context.Context) (json.RawMessage, http.Header, error) {
+	return func(ctx context.Context) (json.RawMessage, http.Header, error) {
+		return s.serve(ctx, h, func(ctx context.Context) (interface{}, error) {
+			return s.dec(ctx, dec)
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"golang.org/x/time/rate"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func countService(options ...jsonrpc.StreamServiceOption) *jsonrpc.StreamService {
+	return jsonrpc.NewStreamService(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			c := make(chan interface{})
+			go func() {
+				defer close(c)
+				for i := 0; i < request.(int); i++ {
+					select {
+					case c <- i:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return c, nil
+		},
+		func(_ context.Context, params json.RawMessage) (interface{}, error) {
+			var n int
+			err := json.Unmarshal(params, &n)
+			return n, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+		options...,
+	)
+}
+
+func TestServerStreamResult(t *testing.T) {
+	const n = 50000
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"count": countService()})
+	resp := post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"count","params":50000}`)
+	if want, have := []string{"chunked"}, resp.TransferEncoding; len(have) != 1 || want[0] != have[0] {
+		t.Errorf("want %v, have %v", want, have)
+	}
+	res := decodeResponse(t, resp)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	var ints []int
+	if err := json.Unmarshal(res.Result, &ints); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := n, len(ints); want != have {
+		t.Fatalf("want %d elements, have %d", want, have)
+	}
+	for i, v := range ints {
+		if i != v {
+			t.Fatalf("element %d: have %d", i, v)
+		}
+	}
+}
+
+func TestServerStreamEmptyResult(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"count": countService()})
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"count","params":0}`))
+	if want, have := `[]`, string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestStreamServiceServeJSONRPC(t *testing.T) {
+	result, _, err := countService().ServeJSONRPC(context.Background(), http.Header{}, json.RawMessage(`3`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `[0,1,2]`, string(result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+// sumService sums a params array of integers, decoding it element by element.
+// If read is non-nil, it's called before decoding starts.
+func sumService(read func(), options ...jsonrpc.ServiceOption) *jsonrpc.ParamsStreamService {
+	return jsonrpc.NewParamsStreamService(
+		func(_ context.Context, request interface{}) (interface{}, error) { return request, nil },
+		func(_ context.Context, dec *json.Decoder) (interface{}, error) {
+			if read != nil {
+				read()
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			var sum int
+			for dec.More() {
+				var n int
+				if err := dec.Decode(&n); err != nil {
+					return nil, err
+				}
+				sum += n
+			}
+			_, err := dec.Token()
+			return sum, err
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		options...,
+	)
+}
+
+// paramsStreamBodies are requests for sum whose params are streamed, and
+// buffered, respectively.
+var paramsStreamBodies = []string{
+	`{"jsonrpc":"2.0","method":"sum","id":1,"params":[1,2]}`,
+	`{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":1}`,
+}
+
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestServerParamsStream(t *testing.T) {
+	const n = 100000
+	var buf bytes.Buffer
+	buf.WriteString(`{"jsonrpc":"2.0","method":"sum","id":1,"params":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("1")
+	}
+	buf.WriteString(`]}`)
+
+	var (
+		body    = &countingReader{r: &buf}
+		atStart int
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(func() { atStart = body.read })})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", body))
+	var res jsonrpc.Response
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if want, have := "100000", string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if atStart > 4096 {
+		t.Errorf("%d bytes of the body were read before decoding the params, want params unbuffered", atStart)
+	}
+}
+
+func TestServerParamsStreamBuffered(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(nil), "add": addService()})
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"params":[1,2,3],"jsonrpc":"2.0","id":1,"method":"sum"}`, `6`},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":1}`, `6`},
+		{`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`, `3`},
+	} {
+		res := decodeResponse(t, post(t, handler, tc.body))
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.body, res.Error)
+		}
+		if want, have := tc.want, string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", tc.body, want, have)
+		}
+	}
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","id":1,"method":"sum","params":[1,"x"]}`))
+	if res.Error == nil {
+		t.Error("want error, have none")
+	}
+}
+
+func TestServerParamsStreamIDAfterParams(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(nil)}, jsonrpc.RequireIDType(jsonrpc.IntegerID))
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"sum","params":[1],"id":"abc"}`,
+		`{"jsonrpc":"2.0","method":"sum","id":"abc","params":[1]}`,
+	} {
+		res := decodeResponse(t, post(t, handler, body))
+		if want, have := jsonrpc.InvalidRequestError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", body, want, have)
+		}
+	}
+
+	res := decodeResponse(t, post(t, handler, `{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":7}`))
+	if want, have := `3`, string(res.Result); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	// Without an id before the params, a notification is only recognized
+	// once the whole request has been read.
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"sum","params":[1,2]}`)
+	resp.Body.Close()
+	if want, have := http.StatusNoContent, resp.StatusCode; want != have {
+		t.Errorf("notification: want status %d, have %d", want, have)
+	}
+}
+
+func TestServerParamsStreamMemberNames(t *testing.T) {
+	// Registering a ParamsStreamHandler mustn't change how the members of
+	// requests for other methods are matched.
+	for name, sm := range map[string]jsonrpc.ServiceMap{
+		"without stream handler": {"add": addService()},
+		"with stream handler":    {"add": addService(), "sum": sumService(nil)},
+	} {
+		res := decodeResponse(t, post(t, jsonrpc.NewServer(sm), `{"JSONRPC":"2.0","Method":"add","Params":[1,2],"ID":1}`))
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", name, res.Error)
+		}
+		if want, have := `3`, string(res.Result); want != have {
+			t.Errorf("%s: want %s, have %s", name, want, have)
+		}
+	}
+}
+
+func TestParamsStreamServiceRecover(t *testing.T) {
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"sum": sumService(func() { panic("decoder exploded") }, jsonrpc.ServiceRecover()),
+	})
+	for _, body := range paramsStreamBodies {
+		res := decodeResponse(t, post(t, handler, body))
+		if want, have := jsonrpc.InternalError, errorCode(t, res); want != have {
+			t.Errorf("%s: want %d, have %d", body, want, have)
+		}
+	}
+}
+
+func TestParamsStreamServiceTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(nil, jsonrpc.ServiceTracing(tracer))})
+	for _, body := range paramsStreamBodies {
+		post(t, handler, body).Body.Close()
+	}
+	spans := tracer.FinishedSpans()
+	if want, have := len(paramsStreamBodies), len(spans); want != have {
+		t.Fatalf("want %d finished spans, have %d", want, have)
+	}
+	for _, span := range spans {
+		if want, have := "sum", span.OperationName; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+}
+
+func TestParamsStreamServiceRateLimit(t *testing.T) {
+	for _, body := range paramsStreamBodies {
+		handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"sum": sumService(nil, jsonrpc.ServiceRateLimit(rate.NewLimiter(0, 1)))})
+		if res := decodeResponse(t, post(t, handler, body)); res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", body, res.Error)
+		}
+		if want, have := jsonrpc.RateLimitedError, errorCode(t, decodeResponse(t, post(t, handler, body))); want != have {
+			t.Errorf("%s: want %d, have %d", body, want, have)
+		}
+	}
+}
+
+func TestParamsStreamServiceDedupNotifications(t *testing.T) {
+	var read int
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"sum": sumService(func() { read++ }, jsonrpc.ServiceDedupNotifications(time.Hour, func(params json.RawMessage) string {
+			return string(params)
+		})),
+	})
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"sum","params":[1,2]}`,
+		`{"jsonrpc":"2.0","method":"sum","params":[1,2]}`,
+		`{"jsonrpc":"2.0","method":"sum","params":[3,4]}`,
+	} {
+		post(t, handler, body).Body.Close()
+	}
+	if want, have := 2, read; want != have {
+		t.Errorf("want %d notifications served, have %d", want, have)
+	}
+}
+
+func TestStreamVersion1(t *testing.T) {
+	handler := jsonrpc.NewServer(
+		jsonrpc.ServiceMap{"count": countService()},
+		jsonrpc.AcceptVersions(jsonrpc.Version1),
+	)
+	resp := post(t, handler, `{"method":"count","params":3,"id":7}`)
+	defer resp.Body.Close()
+	buf, _ := ioutil.ReadAll(resp.Body)
+	if want, have := `{"result":[0,1,2],"error":null,"id":7}`, strings.TrimSpace(string(buf)); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerStreamErrorTrailer(t *testing.T) {
+	failing := jsonrpc.NewStreamService(
+		func(context.Context, interface{}) (interface{}, error) {
+			c := make(chan interface{}, 3)
+			c <- 1
+			c <- 2
+			c <- jsonrpc.Error{Code: -32010, Message: "upstream gone"}
+			close(c)
+			return c, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{"fail": failing})
+	resp := post(t, handler, `{"jsonrpc":"2.0","method":"fail","id":1}`)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `{"jsonrpc":"2.0","id":1,"result":[1,2`, string(body); want != have {
+		t.Errorf("want body %s, have %s", want, have)
+	}
+	var e jsonrpc.Error
+	if err := json.Unmarshal([]byte(resp.Trailer.Get(jsonrpc.ErrorTrailer)), &e); err != nil {
+		t.Fatalf("trailer %q: %v", resp.Trailer.Get(jsonrpc.ErrorTrailer), err)
+	}
+	if want, have := (jsonrpc.Error{Code: -32010, Message: "upstream gone"}), e; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestStreamStopsWhenClientGoes(t *testing.T) {
+	var (
+		active = generic.NewGauge("active_streams")
+		exited = make(chan struct{})
+	)
+	forever := jsonrpc.NewStreamService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			c := make(chan interface{})
+			go func() {
+				defer close(exited)
+				for i := 0; ; i++ {
+					select {
+					case c <- i:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return c, nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+		jsonrpc.StreamServiceActiveStreams(active),
+	)
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{"forever": forever}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader(`{"jsonrpc":"2.0","method":"forever","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1.0, active.Value(); want != have {
+		t.Errorf("want %v active streams, have %v", want, have)
+	}
+
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream goroutine still running after the client went away")
+	}
+	for deadline := time.Now().Add(5 * time.Second); active.Value() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("want 0 active streams, have %v", active.Value())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package jsonrpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// ServiceTracing makes the service start a span with tracer for each request,
+// named after the method being served, as a child of the span propagated in
+// the request headers, if any. The span is put in the context passed to the
+// decoder, the endpoint and the functions around them, and is finished once
+// the request has been served, tagged with the JSON-RPC error code if it
+// failed.
+//
+// Whether the span is sampled is left to tracer, which follows the decision
+// propagated with the parent span, so a caller that didn't sample its span
+// gets no sampled spans from the service. Spans of the methods in
+// forceSampled are sampled regardless, by setting their sampling priority.
+func ServiceTracing(tracer opentracing.Tracer, forceSampled ...string) ServiceOption {
+	return func(s *Service) {
+		s.tracer = tracer
+		s.forceSampled = map[string]bool{}
+		for _, method := range forceSampled {
+			s.forceSampled[method] = true
+		}
+	}
+}
+
+// ClientTracePropagation makes the client inject the context of the span in
+// the context of each call, if any, into the request headers with tracer, so
+// that a server using ServiceTracing continues the trace. By default, no
+// span context is propagated.
+func ClientTracePropagation(tracer opentracing.Tracer) ClientOption {
+	return func(c *Client) { c.tracer = tracer }
+}
+
+// injectSpan injects the context of the span in ctx, if any, into the
+// request headers h. A span context the tracer can't inject is left out.
+func (c Client) injectSpan(ctx context.Context, h http.Header) {
+	span := opentracing.SpanFromContext(ctx)
+	if c.tracer == nil || span == nil {
+		return
+	}
+	c.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+}
+
+// startSpan starts the span of a request with the headers h.
+func (s Service) startSpan(ctx context.Context, h http.Header) (context.Context, opentracing.Span) {
+	method, _ := ctx.Value(contextKeyMethod).(string)
+	var options []opentracing.StartSpanOption
+	if parent, err := s.tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h)); err == nil {
+		options = append(options, ext.RPCServerOption(parent))
+	} else {
+		options = append(options, ext.SpanKindRPCServer)
+		if err != opentracing.ErrSpanContextNotFound {
+			s.logger.Log("err", err)
+		}
+	}
+	span := s.tracer.StartSpan(method, options...)
+	span.SetTag("jsonrpc.method", method)
+	if s.forceSampled[method] {
+		ext.SamplingPriority.Set(span, 1)
+	}
+	return opentracing.ContextWithSpan(ctx, span), span
+}
+
+// finishSpan finishes the span of a request that failed with err, if non-nil.
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("jsonrpc.error_code", ToJSONRPCError(err).Code)
+	}
+	span.Finish()
+}
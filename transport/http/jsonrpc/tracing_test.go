@@ -0,0 +1,148 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func TestServiceTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	var inEndpoint opentracing.Span
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(jsonrpc.ServiceTracing(tracer)),
+		"fail": jsonrpc.NewService(
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				inEndpoint = opentracing.SpanFromContext(ctx)
+				return nil, jsonrpc.Error{Code: -32042, Message: "failed"}
+			},
+			func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			jsonrpc.ServiceTracing(tracer),
+		),
+	})
+
+	parent := tracer.StartSpan("client").(*mocktracer.MockSpan)
+	header := http.Header{}
+	if err := tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		t.Fatal(err)
+	}
+	postHeader(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, header).Body.Close()
+	post(t, handler, `{"jsonrpc":"2.0","method":"fail","id":2}`).Body.Close()
+
+	spans := tracer.FinishedSpans()
+	if want, have := 2, len(spans); want != have {
+		t.Fatalf("want %d finished spans, have %d", want, have)
+	}
+
+	add := spans[0]
+	if want, have := "add", add.OperationName; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := "add", add.Tag("jsonrpc.method"); want != have {
+		t.Errorf("want method tag %q, have %v", want, have)
+	}
+	if want, have := parent.SpanContext.SpanID, add.ParentID; want != have {
+		t.Errorf("want parent %d, have %d", want, have)
+	}
+	if add.Tag("error") != nil {
+		t.Errorf("want no error tag, have %v", add.Tag("error"))
+	}
+
+	fail := spans[1]
+	if want, have := "fail", fail.OperationName; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := true, fail.Tag("error"); want != have {
+		t.Errorf("want error tag %v, have %v", want, have)
+	}
+	if want, have := -32042, fail.Tag("jsonrpc.error_code"); want != have {
+		t.Errorf("want error code tag %v, have %v", want, have)
+	}
+	if want, have := fail.SpanContext.SpanID, inEndpoint.(*mocktracer.MockSpan).SpanContext.SpanID; want != have {
+		t.Errorf("want span %d in the endpoint context, have %d", want, have)
+	}
+}
+
+func TestServiceTracingSampling(t *testing.T) {
+	tracer := mocktracer.New()
+	handler := jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add":    addService(jsonrpc.ServiceTracing(tracer, "always")),
+		"always": addService(jsonrpc.ServiceTracing(tracer, "always")),
+	})
+
+	parent := tracer.StartSpan("client")
+	ext.SamplingPriority.Set(parent, 0)
+	header := http.Header{}
+	if err := tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		t.Fatal(err)
+	}
+	postHeader(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, header).Body.Close()
+	postHeader(t, handler, `{"jsonrpc":"2.0","method":"always","params":[1,2],"id":2}`, header).Body.Close()
+	post(t, handler, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":3}`).Body.Close()
+
+	spans := tracer.FinishedSpans()
+	if want, have := 3, len(spans); want != have {
+		t.Fatalf("want %d finished spans, have %d", want, have)
+	}
+	for i, want := range []bool{false, true, true} {
+		if have := spans[i].SpanContext.Sampled; want != have {
+			t.Errorf("%s (%d): want sampled %v, have %v", spans[i].OperationName, i, want, have)
+		}
+	}
+}
+
+func TestClientTracePropagation(t *testing.T) {
+	tracer := mocktracer.New()
+	var (
+		propagated opentracing.SpanContext
+		extractErr error
+	)
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.ServiceMap{
+		"add": addService(
+			jsonrpc.ServiceBefore(func(ctx context.Context, h http.Header) context.Context {
+				propagated, extractErr = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+				return ctx
+			}),
+			jsonrpc.ServiceTracing(tracer),
+		),
+	}))
+	defer server.Close()
+	tgt, _ := url.Parse(server.URL)
+	client := jsonrpc.NewClient(tgt, "add", jsonrpc.ClientTracePropagation(tracer))
+
+	parent := tracer.StartSpan("client").(*mocktracer.MockSpan)
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	if err := client.Call(ctx, "add", []int{1, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if extractErr != nil {
+		t.Fatalf("want a span context in the request headers, have %v", extractErr)
+	}
+	if want, have := parent.SpanContext.SpanID, propagated.(mocktracer.MockSpanContext).SpanID; want != have {
+		t.Errorf("want span %d propagated, have %d", want, have)
+	}
+	spans := tracer.FinishedSpans()
+	if want, have := 1, len(spans); want != have {
+		t.Fatalf("want %d finished spans, have %d", want, have)
+	}
+	if want, have := parent.SpanContext.SpanID, spans[0].ParentID; want != have {
+		t.Errorf("want parent %d, have %d", want, have)
+	}
+
+	if err := client.Call(context.Background(), "add", []int{1, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := opentracing.ErrSpanContextNotFound, extractErr; want != have {
+		t.Errorf("want %v without a span, have %v", want, have)
+	}
+}
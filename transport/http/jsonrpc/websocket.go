@@ -0,0 +1,99 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/go-kit/kit/log"
+)
+
+// websocketServer serves JSON-RPC over WebSocket connections.
+type websocketServer struct {
+	d        *Dispatcher
+	upgrader websocket.Upgrader
+	logger   log.Logger
+}
+
+// NewWebsocketServer constructs an http.Handler that upgrades each request to
+// a WebSocket connection, on which it serves JSON-RPC requests with the
+// handlers in sm until the client closes it. Each message holds one or more
+// requests or batches, one after the other, e.g. separated by newlines. Each
+// response, or array of responses to a batch, is sent back as a message of
+// its own, as soon as it's ready, so responses may come out of order and are
+// correlated by id. Notifications get no response. Endpoints may push
+// notifications of their own to the client with NotifierFromContext. The
+// options are those of the Dispatcher serving the requests, except that the
+// ServerBefore and ServerErroringBefore functions, including those of
+// ServerAuthenticator and BearerAuth, are run once on the upgrade request: if
+// one fails, the connection isn't upgraded and the error is passed to the
+// error encoder instead. Handlers are passed the headers of the upgrade
+// request. The connection is closed once reading from it fails, after the
+// requests in flight have been served.
+func NewWebsocketServer(sm ServiceMap, options ...ServerOption) http.Handler {
+	d := NewDispatcher(sm, options...)
+	return websocketServer{d: d, logger: d.s.logger}
+}
+
+func (s websocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := s.d.s.withValues(context.WithValue(r.Context(), contextKeyRequestHeader, r.Header))
+	ctx, audit := s.d.s.startAudit(ctx)
+	defer audit()
+	ctx, err := s.d.s.runBefore(ctx, r.Header)
+	if err != nil {
+		s.d.s.fail(ctx, w, err)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Log("err", err)
+		return // Upgrade answered the request already
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mtx sync.Mutex // serializes writes, as required by websocket.Conn
+	send := func(v interface{}) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err := conn.WriteJSON(v); err != nil {
+			s.logger.Log("err", err)
+			return err
+		}
+		return nil
+	}
+	ctx = context.WithValue(ctx, contextKeyNotifier, sendNotifier(send))
+	c := newConn(s.d, send)
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.logger.Log("err", err)
+			}
+			break
+		}
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		for {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err == io.EOF {
+				break
+			} else if err != nil {
+				// The rest of the message isn't valid JSON, and neither is
+				// an empty request, which the Dispatcher answers with a
+				// ParseError.
+				send(wireResponse(s.d.Dispatch(ctx, nil)))
+				break
+			}
+			c.serve(ctx, raw)
+		}
+	}
+	cancel()
+	c.wait()
+}
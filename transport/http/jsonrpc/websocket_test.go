@@ -0,0 +1,380 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+func dialWebsocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestWebsocketServer(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(jsonrpc.ServiceMap{"add": addService()}))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	// Two requests in a message of their own each, with a notification in
+	// between, and a batch after them, sharing a message with the second.
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`,
+		`{"jsonrpc":"2.0","method":"add","params":[0,0]}`,
+		`{"jsonrpc":"2.0","id":2,"method":"add","params":[3,4]}` + "\n" +
+			`[{"jsonrpc":"2.0","id":3,"method":"add","params":[5,6]},{"jsonrpc":"2.0","id":4,"method":"sub"}]`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := map[string]string{}
+	for i := 0; i < 3; i++ {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var batch []jsonrpc.Response
+		if strings.HasPrefix(string(msg), "[") {
+			err = json.Unmarshal(msg, &batch)
+		} else {
+			var res jsonrpc.Response
+			err = json.Unmarshal(msg, &res)
+			batch = append(batch, res)
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", err, msg)
+		}
+		for _, res := range batch {
+			if res.Error != nil {
+				results[string(res.ID)] = res.Error.Message
+				continue
+			}
+			results[string(res.ID)] = string(res.Result)
+		}
+	}
+	want := map[string]string{"1": "3", "2": "7", "3": "11", "4": "Method not found: sub"}
+	for id, result := range want {
+		if have := results[id]; result != have {
+			t.Errorf("id %s: want %s, have %s", id, result, have)
+		}
+	}
+	if len(results) != len(want) {
+		t.Errorf("want %d responses, have %v", len(want), results)
+	}
+}
+
+func TestWebsocketServerParseError(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(jsonrpc.ServiceMap{"add": addService()}))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":2,"method":"add","params":[1,1]}`))
+	for _, want := range []string{`"code":-32700`, `"result":2`} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(msg), want) {
+			t.Errorf("want %s in %s", want, msg)
+		}
+	}
+}
+
+func TestWebsocketServerNotifier(t *testing.T) {
+	subscribe := jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			n := jsonrpc.NotifierFromContext(ctx)
+			for i := 1; i <= 2; i++ {
+				if err := n.Notify("update", map[string]int{"seq": i}); err != nil {
+					return nil, err
+				}
+			}
+			return "subscribed", nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	sm := jsonrpc.ServiceMap{"subscribe": subscribe}
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(sm))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"subscribe"}`))
+	for _, want := range []string{
+		`{"jsonrpc":"2.0","method":"update","params":{"seq":1}}`,
+		`{"jsonrpc":"2.0","method":"update","params":{"seq":2}}`,
+		`{"jsonrpc":"2.0","id":1,"result":"subscribed"}`,
+	} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := strings.TrimSpace(string(msg)); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	}
+
+	// Over HTTP, there's no connection to push notifications on.
+	res := decodeResponse(t, post(t, jsonrpc.NewServer(sm), `{"jsonrpc":"2.0","id":1,"method":"subscribe"}`))
+	if res.Error == nil || !strings.Contains(res.Error.Message, "doesn't support server notifications") {
+		t.Errorf("want ErrNotifyUnsupported, have %+v", res)
+	}
+}
+
+func TestWebsocketServerBefore(t *testing.T) {
+	type claimsKey struct{}
+	whoami := jsonrpc.NewService(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return []interface{}{ctx.Value(claimsKey{}).(jsonrpc.Claims)["sub"], request}, nil
+		},
+		func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+			return jsonrpc.RequestHeader(ctx).Get("X-Client"), nil
+		},
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(
+		jsonrpc.ServiceMap{"whoami": whoami},
+		jsonrpc.BearerAuth(func(token string) (jsonrpc.Claims, error) {
+			if token != "secret" {
+				return nil, errors.New("unknown token")
+			}
+			return jsonrpc.Claims{"sub": "alice"}, nil
+		}, claimsKey{}),
+	))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer wrong"}})
+	if err == nil {
+		t.Fatal("want the upgrade refused, have a connection")
+	}
+	if want, have := http.StatusUnauthorized, resp.StatusCode; want != have {
+		t.Errorf("want status %d, have %d", want, have)
+	}
+	if want, have := jsonrpc.UnauthorizedError, errorCode(t, decodeResponse(t, resp)); want != have {
+		t.Errorf("want code %d, have %d", want, have)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{
+		"Authorization": {"Bearer secret"},
+		"X-Client":      {"cli"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"whoami"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var res jsonrpc.Response
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `["alice","cli"]`, string(res.Result); want != have {
+		t.Errorf("want %s, have %s (%v)", want, have, res.Error)
+	}
+}
+
+// blockService returns a service whose endpoint blocks until its context is
+// done, after signaling on entered, if non-nil.
+func blockService(entered chan<- struct{}) *jsonrpc.Service {
+	return jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			if entered != nil {
+				entered <- struct{}{}
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+}
+
+func TestWebsocketServerCancelNotifications(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(
+		jsonrpc.ServiceMap{"block": blockService(entered), "add": addService()},
+		jsonrpc.CancelNotifications(jsonrpc.CancelRequestMethod),
+	))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":"a","method":"block"}`))
+	<-entered
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"b"}}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"a"}}`))
+	var res jsonrpc.Response
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `"a"`, string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+	if res.Error == nil || res.Error.Code != jsonrpc.RequestCanceledError {
+		t.Errorf("want RequestCanceledError, have %+v", res)
+	}
+
+	// The connection keeps serving requests.
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s (%v)", want, have, res.Error)
+	}
+}
+
+func TestWebsocketServerProgress(t *testing.T) {
+	count := jsonrpc.NewService(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			for i := 1; i <= 2; i++ {
+				if err := jsonrpc.Progress(ctx, i*50); err != nil {
+					return nil, err
+				}
+			}
+			return "done", nil
+		},
+		func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+	)
+	sm := jsonrpc.ServiceMap{"count": count}
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(sm))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":7,"method":"count"}`))
+	for _, want := range []string{
+		`{"jsonrpc":"2.0","method":"$/progress","params":{"id":7,"value":50}}`,
+		`{"jsonrpc":"2.0","method":"$/progress","params":{"id":7,"value":100}}`,
+		`{"jsonrpc":"2.0","id":7,"result":"done"}`,
+	} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := strings.TrimSpace(string(msg)); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	}
+
+	// Over HTTP, there's no connection to report progress on.
+	res := decodeResponse(t, post(t, jsonrpc.NewServer(sm), `{"jsonrpc":"2.0","id":1,"method":"count"}`))
+	if res.Error == nil || !strings.Contains(res.Error.Message, "doesn't support server notifications") {
+		t.Errorf("want ErrNotifyUnsupported, have %+v", res)
+	}
+}
+
+func TestWebsocketServerPerConnectionRateLimit(t *testing.T) {
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(
+		jsonrpc.ServiceMap{"add": addService()},
+		jsonrpc.PerConnectionRateLimit(rate.Every(time.Hour), 2),
+	))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	for id := 1; id <= 4; id++ {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":`+strconv.Itoa(id)+`,"method":"add","params":[1,2]}`))
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"add","params":[1,2]}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`[{"jsonrpc":"2.0","id":5,"method":"add","params":[1,2]}]`))
+	results := map[string]string{}
+	for i := 0; i < 5; i++ {
+		var res jsonrpc.Response
+		if err := conn.ReadJSON(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error != nil {
+			results[string(res.ID)] = strconv.Itoa(res.Error.Code)
+			continue
+		}
+		results[string(res.ID)] = string(res.Result)
+	}
+	limited := strconv.Itoa(jsonrpc.RateLimitedError)
+	want := map[string]string{"1": "3", "2": "3", "3": limited, "4": limited, "null": limited}
+	if !reflect.DeepEqual(want, results) {
+		t.Errorf("want %v, have %v", want, results)
+	}
+
+	// Each connection has a limiter of its own.
+	other := dialWebsocket(t, server.URL)
+	defer other.Close()
+	other.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"add","params":[1,2]}`))
+	var res jsonrpc.Response
+	if err := other.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s (%v)", want, have, res.Error)
+	}
+}
+
+func TestWebsocketServerMaxPendingRequests(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	server := httptest.NewServer(jsonrpc.NewWebsocketServer(
+		jsonrpc.ServiceMap{"block": blockService(entered), "add": addService()},
+		jsonrpc.MaxPendingRequests(2),
+		jsonrpc.CancelNotifications(jsonrpc.CancelRequestMethod),
+	))
+	defer server.Close()
+	conn := dialWebsocket(t, server.URL)
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"block"}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":2,"method":"block"}`))
+	<-entered
+	<-entered
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"add","params":[1,2]}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":3,"method":"add","params":[1,2]}`))
+	var res jsonrpc.Response
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "3", string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+	if res.Error == nil || res.Error.Code != jsonrpc.ServerBusyError {
+		t.Errorf("want ServerBusyError, have %+v", res)
+	}
+
+	// Once a pending request is answered, there's room for another.
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":1}}`))
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "1", string(res.ID); want != have {
+		t.Errorf("want id %s, have %s", want, have)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":4,"method":"add","params":[1,2]}`))
+	if err := conn.ReadJSON(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "3", string(res.Result); want != have {
+		t.Errorf("want %s, have %s (%v)", want, have, res.Error)
+	}
+}